@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mwildt/load-monitor/pkg/config"
+	"github.com/mwildt/load-monitor/pkg/runs"
+)
+
+// runCompare implementiert das Subkommando "compare": loadmonitor compare -a <id|datei> -b <id|datei> -format md
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	a := flags.String("a", "", "id or result file of the first run")
+	b := flags.String("b", "", "id or result file of the second run")
+	dataDir := flags.String("data-dir", config.EnvDataDir(os.Getenv), "data directory of the monitor (env LOADMONITOR_DATA_DIR)")
+	dir := flags.String("dir", "", "directory of the stored run results (default <data-dir>/runs)")
+	format := flags.String("format", "md", "report format: md, html or json")
+	flags.Parse(args)
+
+	if *a == "" || *b == "" {
+		flags.Usage()
+		return fmt.Errorf("a and b are required")
+	}
+	if *dir == "" {
+		*dir = config.Config{DataDir: *dataDir}.RunsDir()
+	}
+	resultA, err := readRunResult(*dir, *a)
+	if err != nil {
+		return err
+	}
+	resultB, err := readRunResult(*dir, *b)
+	if err != nil {
+		return err
+	}
+	comparison := runs.Compare(resultA.Run, resultB.Run)
+	switch *format {
+	case "md", "markdown":
+		return runs.RenderMarkdown(os.Stdout, comparison)
+	case "html":
+		return runs.RenderHTML(os.Stdout, comparison)
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(comparison)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func readRunResult(dir string, idOrFile string) (runs.Result, error) {
+	if strings.HasSuffix(idOrFile, ".json") {
+		return runs.ReadResult(idOrFile)
+	}
+	return runs.ReadResult(filepath.Join(dir, idOrFile+".json"))
+}
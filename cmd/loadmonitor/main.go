@@ -1,19 +1,47 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/mwildt/load-monitor/pkg/broker"
+	"github.com/mwildt/load-monitor/pkg/config"
 	"github.com/mwildt/load-monitor/pkg/connection"
+	"github.com/mwildt/load-monitor/pkg/counter"
+	"github.com/mwildt/load-monitor/pkg/fault"
+	"github.com/mwildt/load-monitor/pkg/grpcsensor"
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/history"
+	"github.com/mwildt/load-monitor/pkg/latency"
+	"github.com/mwildt/load-monitor/pkg/metric"
+	"github.com/mwildt/load-monitor/pkg/netsensor"
+	"github.com/mwildt/load-monitor/pkg/payload"
+	"github.com/mwildt/load-monitor/pkg/prometheus"
+	"github.com/mwildt/load-monitor/pkg/recorder"
+	"github.com/mwildt/load-monitor/pkg/runs"
 	"github.com/mwildt/load-monitor/pkg/session"
 	"github.com/mwildt/load-monitor/pkg/store"
 	"github.com/mwildt/load-monitor/pkg/utils"
+	"github.com/mwildt/load-monitor/pkg/ws"
 	"golang.org/x/crypto/bcrypt"
+	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -131,10 +159,121 @@ func SystemInfoHandler() http.HandlerFunc {
 	}
 }
 
-func DefaultHandler() http.HandlerFunc {
+func DefaultHandler(payloads *payload.Generator) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		//log.Printf("DefaultHandler %s::%s\n", request.Method, request.URL.String())
-		writer.WriteHeader(http.StatusOK)
+		payloads.Serve(writer, request)
+	}
+}
+
+// ConfigHandler liefert bei GET die aktuelle Konfiguration und übernimmt bei PUT eine neue
+func ConfigHandler[T any](get func() T, set func(T) error) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet {
+			utils.OkJson(writer, request, get())
+		} else if config, err := utils.ReadJsonBody[T](request); err != nil {
+			utils.BadRequestError(writer, request, err)
+		} else if err = set(config); err != nil {
+			utils.BadRequestError(writer, request, err)
+		} else {
+			utils.OkJson(writer, request, get())
+		}
+	}
+}
+
+// EchoHandler spiegelt den empfangenen Request als JSON zurück
+func EchoHandler() http.HandlerFunc {
+	type (
+		Cookie struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		}
+		Body struct {
+			Size   int64  `json:"size"`
+			Sha256 string `json:"sha256"`
+		}
+		Echo struct {
+			Method     string              `json:"method"`
+			Proto      string              `json:"proto"`
+			Host       string              `json:"host"`
+			Path       string              `json:"path"`
+			Query      map[string][]string `json:"query"`
+			Headers    map[string][]string `json:"headers"`
+			Cookies    []Cookie            `json:"cookies"`
+			Body       Body                `json:"body"`
+			RemoteAddr string              `json:"remoteAddr"`
+		}
+	)
+	return func(writer http.ResponseWriter, request *http.Request) {
+		hash := sha256.New()
+		size, err := io.Copy(hash, request.Body)
+		if err != nil {
+			utils.BadRequestError(writer, request, err)
+			return
+		}
+		cookies := make([]Cookie, 0)
+		for _, cookie := range request.Cookies() {
+			cookies = append(cookies, Cookie{cookie.Name, cookie.Value})
+		}
+		utils.OkJson(writer, request, Echo{
+			Method:     request.Method,
+			Proto:      request.Proto,
+			Host:       request.Host,
+			Path:       request.URL.Path,
+			Query:      request.URL.Query(),
+			Headers:    request.Header,
+			Cookies:    cookies,
+			Body:       Body{size, hex.EncodeToString(hash.Sum(nil))},
+			RemoteAddr: request.RemoteAddr,
+		})
+	}
+}
+
+// parseTime akzeptiert Unix-Millisekunden oder RFC3339
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	} else if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// HistoryHandler liefert den Verlauf eines Keys; ohne key-Parameter die Liste der aufgezeichneten Keys
+func HistoryHandler(valueHistory *history.History) http.HandlerFunc {
+	type Response struct {
+		Key        string          `json:"key"`
+		Resolution string          `json:"resolution"`
+		From       time.Time       `json:"from"`
+		To         time.Time       `json:"to"`
+		Points     []history.Point `json:"points"`
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		key := query.Get("key")
+		if key == "" {
+			utils.OkJson(writer, request, valueHistory.Keys())
+			return
+		}
+		now := time.Now()
+		from, err := parseTime(query.Get("from"), now.Add(-time.Hour))
+		if err != nil {
+			utils.BadRequestError(writer, request, err)
+			return
+		}
+		to, err := parseTime(query.Get("to"), now)
+		if err != nil {
+			utils.BadRequestError(writer, request, err)
+			return
+		}
+		resolution, points := valueHistory.Query(key, from, to)
+		utils.OkJson(writer, request, Response{
+			Key:        key,
+			Resolution: resolution.Interval.String(),
+			From:       from,
+			To:         to,
+			Points:     points,
+		})
 	}
 }
 
@@ -216,7 +355,9 @@ func createMessage[T any](key string, value T) any {
 	}
 }
 
-func streamHandler(valueStore *store.Store) http.HandlerFunc {
+// streamHandler sendet zuerst die Beschreibungen der Metriken (store.meta), danach alle Werte und deren Änderungen.
+// Kommt der Client nicht hinterher, gilt overflow bzw. der Query-Parameter overflow (siehe broker.Policy).
+func streamHandler(valueStore *store.Store, metas func() []metric.Meta, throttle time.Duration, pingInterval time.Duration, overflow string) http.HandlerFunc {
 
 	return func(writer http.ResponseWriter, request *http.Request) {
 		flusher, ok := writer.(http.Flusher)
@@ -224,15 +365,23 @@ func streamHandler(valueStore *store.Store) http.HandlerFunc {
 			http.Error(writer, "Streaming unsupported", http.StatusInternalServerError)
 			return
 		}
+		policy := overflow
+		if request.URL.Query().Has("overflow") {
+			policy = request.URL.Query().Get("overflow")
+			if err := broker.ValidateOverflow(policy); err != nil {
+				utils.BadRequestError(writer, request, err)
+				return
+			}
+		}
 
-		timeout := time.NewTimer(10 * time.Second)
+		timeout := time.NewTimer(pingInterval)
 		defer timeout.Stop()
 
 		resetTimer := func() {
-			timeout.Reset(10 * time.Second)
+			timeout.Reset(pingInterval)
 		}
 
-		bytesBrokerRegistration, storeChannel, err := valueStore.RegisterThrottled(store.All(), 250*time.Millisecond)
+		bytesBrokerRegistration, storeChannel, err := valueStore.RegisterThrottled(store.All(), throttle, policy)
 		defer valueStore.Cancel(bytesBrokerRegistration)
 		if err != nil {
 			fmt.Fprintf(writer, "Error registering bytes: %v\n", err)
@@ -244,6 +393,7 @@ func streamHandler(valueStore *store.Store) http.HandlerFunc {
 		writer.Header().Set("Cache-Control", "no-cache")
 		writer.Header().Set("Connection", "keep-alive")
 
+		sendJsonEvent(writer, "store.meta", metas())
 		for key, value := range valueStore.Entries() {
 			sendJsonEvent(writer, "store.event", createMessage(key, value))
 		}
@@ -252,7 +402,12 @@ func streamHandler(valueStore *store.Store) http.HandlerFunc {
 
 		for {
 			select {
-			case events := <-storeChannel:
+			case <-request.Context().Done():
+				return
+			case events, ok := <-storeChannel:
+				if !ok {
+					return
+				}
 				for _, event := range events {
 					sendJsonEvent(writer, "store.event", createMessage(event.Key, event.Value))
 				}
@@ -281,7 +436,8 @@ func requireSession[T any](sessions *session.Store[T], sessionKey string, next h
 	}
 }
 
-func createListener(valueStore *store.Store, network string, address string) *connection.CountingListener {
+// createListener zählt die gelesenen und geschriebenen Bytes in read und written und drosselt per shaper
+func createListener(network string, address string, read *metric.Counter, written *metric.Counter, shaper *connection.Shaper) *connection.CountingListener {
 	ln, err := net.Listen(network, address)
 	if err != nil {
 		panic(err)
@@ -289,92 +445,538 @@ func createListener(valueStore *store.Store, network string, address string) *co
 
 	return &connection.CountingListener{
 		Listener: ln,
+		Shaper:   shaper,
 		ReadConsumer: func(n int) {
-			valueStore.Reduce("bytes.read.count", func(v any) any {
-				return int64(n) + v.(int64)
-			})
+			read.Add(int64(n))
 		},
 		WriteConsumer: func(n int) {
-			valueStore.Reduce("bytes.write.count", func(v any) any {
-				return int64(n) + v.(int64)
-			})
+			written.Add(int64(n))
 		},
 	}
 }
 
+// trackConnections registriert die Verbindungsmetriken (conn.*) eines Listeners; Requests je Verbindung
+// zählt der Handler über connection.FromContext
+func trackConnections(listener *connection.CountingListener, registry *metric.Registry, lifetimes *histogram.Histogram, requests *histogram.Histogram) {
+	var open atomic.Int64
+	openGauge := registry.Gauge("conn.open", metric.Meta{Label: "Open Connections", Description: "open sensor connections"})
+	accepted := registry.Counter("conn.accepted.count", metric.Meta{Label: "Accepted Connections", Description: "accepted sensor connections"})
+	closed := registry.Family("conn.closed.", metric.Meta{Label: "Connections closed by", Description: "closed sensor connections by reason"},
+		connection.CloseClient, connection.CloseServer, connection.CloseTimeout, connection.CloseError)
+	listener.AcceptConsumer = func(*connection.CountingConn) {
+		openGauge.Set(float64(open.Add(1)))
+		accepted.Inc()
+	}
+	listener.CloseConsumer = func(conn *connection.CountingConn, reason string) {
+		openGauge.Set(float64(open.Add(-1)))
+		closed.Inc(reason)
+		lifetimes.Record(conn.Lifetime().Nanoseconds())
+		requests.Record(conn.Requests())
+	}
+}
+
+// publishBrokerStats registriert die Metriken unter broker.* und veröffentlicht im Hintergrund jede Sekunde die
+// Statistik der Store-Subscriber (v.a. der Streams)
+func publishBrokerStats(ctx context.Context, valueStore *store.Store, registry *metric.Registry) {
+	subscribers := registry.Gauge("broker.subscribers", metric.Meta{Description: "subscribers of store events, e.g. dashboard streams"})
+	lagging := registry.Gauge("broker.lagging", metric.Meta{Label: "Lagging Subscribers", Description: "subscribers with a buffer at least half full"})
+	dropped := registry.Counter("broker.dropped.count", metric.Meta{Label: "Dropped Events", Description: "store events dropped for slow subscribers"})
+	coalesced := registry.Counter("broker.coalesced.count", metric.Meta{Description: "store events replaced by a newer event of the same key"})
+	disconnected := registry.Counter("broker.disconnected.count", metric.Meta{Description: "subscribers disconnected for being too slow"})
+	go func() {
+		var last broker.Stats
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats := valueStore.Stats()
+				subscribers.Set(float64(stats.Subscribers))
+				lagging.Set(float64(stats.Lagging))
+				// die Zähler des Brokers laufen seit dem Start, übernommen wird nur die Differenz (Reset bleibt wirksam)
+				dropped.Add(stats.Dropped - last.Dropped)
+				coalesced.Add(stats.Coalesced - last.Coalesced)
+				disconnected.Add(stats.Disconnected - last.Disconnected)
+				last = stats
+			}
+		}
+	}()
+}
+
+// sensorMetrics sind die Metriken des HTTP-Sensors; Bytes und Verbindungen zählt der Listener
+type sensorMetrics struct {
+	requests        *metric.Meter
+	routes          *metric.Family
+	protocols       *metric.Family
+	statuses        *metric.Family
+	classes         *metric.Family
+	latencies       *metric.Histogram
+	sessions        *metric.Gauge
+	sessionsCreated *metric.Meter
+	faults          *metric.Counter
+	faultRules      *metric.Family
+}
+
+func newSensorMetrics(registry *metric.Registry) sensorMetrics {
+	m := sensorMetrics{
+		requests:        registry.Meter("request.count", "request.rate", metric.Meta{Label: "Requests", Description: "requests on the sensor endpoint"}, 1, 60),
+		routes:          registry.Family("request.count.", metric.Meta{Label: "Requests by Route", Description: "sensor requests by route"}),
+		protocols:       registry.Family("request.protocol.", metric.Meta{Label: "Requests by Protocol", Description: "sensor requests by protocol"}),
+		sessions:        registry.Gauge("session.count", metric.Meta{Label: "Active Sessions", Description: "active sensor sessions"}),
+		sessionsCreated: registry.Meter("session.created.count", "session.rate", metric.Meta{Label: "Sessions", Description: "created sensor sessions"}, 1),
+		statuses:        registry.Family("response.status.", metric.Meta{Label: "Responses by Status", Description: "sensor responses by status code"}),
+		classes:         registry.Family("response.class.", metric.Meta{Description: "sensor responses by status class"}),
+	}
+	for _, class := range []string{"2xx", "4xx", "5xx"} {
+		registry.Counter("response.class."+class, metric.Meta{Label: class + " Responses", Description: "sensor responses with status " + class})
+	}
+	m.latencies = registry.Histogram("request.latency", histogram.New(), metric.Meta{Label: "Latency", Unit: metric.UnitMilliseconds, Description: "duration of sensor requests"},
+		"p50", "p90", "p99", "p999", "max")
+	m.faults = registry.Counter("fault.injected.count", metric.Meta{Description: "injected faults"})
+	m.faultRules = registry.Family("fault.injected.", metric.Meta{Description: "injected faults by rule"})
+	return m
+}
+
+// Client hat die Verbindung vor der Antwort geschlossen (Konvention von nginx)
+const statusClientClosedRequest = 499
+
+func recordRequest(requestRecorder *recorder.Recorder, request *http.Request, responseHeader http.Header, status int, body *recorder.DigestReader, start time.Time, duration time.Duration) {
+	bodySize, bodySha256 := body.Finish()
+	sessionId, _ := utils.ReadSessionId(request, "sid")
+	if sessionId == "" {
+		// Login: die Session entsteht erst mit der Antwort
+		for _, header := range responseHeader.Values("Set-Cookie") {
+			if cookie, err := http.ParseSetCookie(header); err == nil && cookie.Name == "sid" {
+				sessionId = cookie.Value
+			}
+		}
+	}
+	err := requestRecorder.Record(recorder.Record{
+		Time:       start,
+		Method:     request.Method,
+		Path:       request.URL.Path,
+		Query:      request.URL.RawQuery,
+		Headers:    request.Header,
+		BodySize:   bodySize,
+		BodySha256: bodySha256,
+		Latency:    utils.Duration(duration),
+		Status:     status,
+		SessionId:  sessionId,
+	})
+	if err != nil {
+		log.Printf("error recording request: %v", err)
+	}
+}
+
 type SensorSessionValue struct{}
 
 func main() {
 
-	valueStore := store.NewStore(map[string]any{
-		"session.count":     0,
-		"bytes.read.count":  int64(0),
-		"bytes.write.count": int64(0),
-		"request.count":     0,
-	})
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "compare" {
+		if err := runCompare(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if err = os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		log.Fatalf("cannot create data directory: %v", err)
+	}
+	startedAt := time.Now()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	valueStore := store.NewStore(map[string]any{})
+	counters := counter.NewCounters()
+	go counters.Run(ctx, valueStore, cfg.CounterInterval.Duration())
+	registry := metric.NewRegistry(valueStore, counters, 1, 10, 60)
+	bytesRead := registry.Meter("bytes.read.count", "bytes.read.rate", metric.Meta{Label: "Bytes read", Unit: metric.UnitBytes, Description: "bytes read on sensor connections"}, 1)
+	bytesWritten := registry.Meter("bytes.write.count", "bytes.write.rate", metric.Meta{Label: "Bytes written", Unit: metric.UnitBytes, Description: "bytes written on sensor connections"}, 1)
+	sensorMetrics := newSensorMetrics(registry)
 
+	handshakes := histogram.New()
+	socketRtt := registry.Histogram("ws.rtt", histogram.New(), metric.Meta{Label: "WebSocket RTT", Unit: metric.UnitMilliseconds, Description: "ping/pong round-trip time of sensor websockets"}, "p50")
+	messageRtt := registry.Histogram("ws.message.rtt", histogram.New(), metric.Meta{Unit: metric.UnitMilliseconds, Description: "round-trip time of push messages echoed by websocket clients"})
+	websockets, err := ws.NewHandler(cfg.WebSocket, registry, socketRtt.Histogram, messageRtt.Histogram)
+	if err != nil {
+		panic(err)
+	}
+	grpcSensor, err := grpcsensor.New(cfg.GRPC.Injection, registry)
+	if err != nil {
+		panic(err)
+	}
+	tlsConfig, err := sensorTLS(cfg.Sensor, registry, handshakes)
+	if err != nil {
+		panic(err)
+	}
+	latencyInjector, err := latency.NewInjector(cfg.Latency)
+	if err != nil {
+		panic(err)
+	}
+	faultInjector, err := fault.NewInjector(cfg.Faults)
+	if err != nil {
+		panic(err)
+	}
+	payloads, err := payload.NewGenerator(cfg.Payload)
+	if err != nil {
+		panic(err)
+	}
+	var requestRecorder *recorder.Recorder
+	if cfg.Record.File != "" {
+		if requestRecorder, err = recorder.NewRecorder(cfg.Record.File, int64(cfg.Record.MaxSize), cfg.Record.MaxFiles); err != nil {
+			panic(err)
+		}
+	}
+	routes := utils.NewRouteKeys(cfg.Sensor.RouteLimit)
 	sensorSessionStore := session.NewSessionStore[SensorSessionValue]()
-	tcpListener := createListener(valueStore, "tcp", ":8081")
+	shaper, err := connection.NewShaper(cfg.Shaping)
+	if err != nil {
+		panic(err)
+	}
+	tcpListener := createListener("tcp", cfg.Sensor.Addr, bytesRead.Counter, bytesWritten.Counter, shaper)
+	connLifetimes := registry.Histogram("conn.lifetime", histogram.New(), metric.Meta{Unit: metric.UnitMilliseconds, Description: "lifetime of closed sensor connections"})
+	connRequests := registry.Histogram("conn.requests", histogram.New(), metric.Meta{Label: "Requests/Connection", Description: "requests per closed sensor connection"}, "p50")
+	trackConnections(tcpListener, registry, connLifetimes.Histogram, connRequests.Histogram)
+
+	// published sind die Histogramme, deren Perzentile im Store veröffentlicht werden
+	published := []*metric.Histogram{sensorMetrics.latencies, socketRtt, messageRtt, connLifetimes, connRequests}
+	histograms := []prometheus.Histogram{{
+		Name:      "request_duration_seconds",
+		Help:      "duration of sensor requests as measured by the server",
+		Histogram: sensorMetrics.latencies.Histogram,
+		Unit:      time.Nanosecond,
+	}, {
+		Name:      "connection_lifetime_seconds",
+		Help:      "lifetime of closed sensor connections",
+		Histogram: connLifetimes.Histogram,
+		Unit:      time.Nanosecond,
+	}, {
+		Name:      "websocket_rtt_seconds",
+		Help:      "ping/pong round-trip time of sensor websockets",
+		Histogram: socketRtt.Histogram,
+		Unit:      time.Nanosecond,
+	}, {
+		Name:      "websocket_message_rtt_seconds",
+		Help:      "round-trip time of push messages echoed by websocket clients",
+		Histogram: messageRtt.Histogram,
+		Unit:      time.Nanosecond,
+	}}
+	if tlsConfig != nil {
+		published = append(published, registry.Histogram("tls.handshake.duration", handshakes, metric.Meta{Unit: metric.UnitMilliseconds, Description: "duration of TLS handshakes on the sensor endpoint"}))
+		histograms = append(histograms, prometheus.Histogram{
+			Name:      "tls_handshake_duration_seconds",
+			Help:      "duration of TLS handshakes on the sensor endpoint",
+			Histogram: handshakes,
+			Unit:      time.Nanosecond,
+		})
+	}
+	sensorServer := runSensorEndpoint(sensorSessionStore, tcpListener, sensorMetrics, routes, latencyInjector, faultInjector, payloads, websockets, requestRecorder, tlsConfig, sensorProtocols(cfg.Sensor), cfg.Sensor.IdleTimeout.Duration())
+	tcpSensor, udpSensor := runNetSensors(registry, cfg.TCP, cfg.UDP, shaper)
+	if cfg.GRPC.Addr != "" {
+		// je Methode unter grpc.latency.<method>, wie grpc.requests.<method>
+		grpcLatencies := grpcSensor.Latencies()
+		for _, method := range slices.Sorted(maps.Keys(grpcLatencies)) {
+			published = append(published, registry.Histogram("grpc.latency."+method, grpcLatencies[method], metric.Meta{Unit: metric.UnitMilliseconds, Description: "duration of gRPC sensor calls to " + method}))
+			histograms = append(histograms, prometheus.Histogram{
+				Name:      "grpc_call_duration_seconds",
+				Help:      "duration of gRPC sensor calls by method, for streams the lifetime of the stream",
+				Histogram: grpcLatencies[method],
+				Unit:      time.Nanosecond,
+				Labels:    map[string]string{"method": method},
+			})
+		}
+		listener := createListener("tcp", cfg.GRPC.Addr,
+			registry.Counter("grpc.bytes.read.count", metric.Meta{Unit: metric.UnitBytes, Description: "bytes read on grpc connections"}),
+			registry.Counter("grpc.bytes.write.count", metric.Meta{Unit: metric.UnitBytes, Description: "bytes written on grpc connections"}), shaper)
+		log.Printf("start grpc sensor on %s", listener.Addr().String())
+		go func() {
+			if err := grpcSensor.Serve(listener); err != nil {
+				log.Fatalf("grpc sensor failed: %v", err)
+			}
+		}()
+	}
 
-	go runSensorEndpoint(sensorSessionStore, tcpListener, valueStore)
-	go runControlEndpoint(valueStore, func() {
-		valueStore.Reset()
+	publishBrokerStats(ctx, valueStore, registry)
+	valueHistory := history.New(history.DefaultResolutions()...)
+	go valueHistory.Run(ctx, valueStore)
+	// der Sampler kennt nur die bis hier registrierten Meter
+	go registry.Sampler().Run(ctx, valueStore, time.Second)
+	for _, hist := range published {
+		go hist.Run(ctx, cfg.Control.StreamThrottle.Duration())
+	}
+	metrics := prometheus.Handler(valueStore, histograms, cfg.Control.MetricsToken)
+	resetAction := func() {
+		sensorMetrics.latencies.Reset()
+		handshakes.Reset()
+		socketRtt.Reset()
+		messageRtt.Reset()
+		connLifetimes.Reset()
+		connRequests.Reset()
+		for _, latencies := range grpcSensor.Latencies() {
+			latencies.Reset()
+		}
+		if udpSensor != nil {
+			udpSensor.Reset()
+		}
+		routes.Reset()
+		counters.Reset(valueStore)
 		sensorSessionStore.Reset()
-	}, ":8082")
+	}
+	runStore, err := runs.NewFileStore(cfg.RunsDir())
+	if err != nil {
+		panic(err)
+	}
+	// snapshot überträgt zuvor Zähler und Perzentile, damit eingefrorene Werte nicht bis zu CounterInterval bzw.
+	// StreamThrottle alt sind
+	snapshot := func() map[string]any {
+		counters.Flush(valueStore)
+		for _, hist := range published {
+			hist.Publish()
+		}
+		return valueStore.Entries()
+	}
+	runManager := runs.NewManager(snapshot, resetAction, func(run runs.Run) {
+		result := runs.Result{Run: run, History: valueHistory.QueryAll(run.StartedAt, *run.StoppedAt)}
+		if err := runStore.Save(result); err != nil {
+			log.Printf("error saving run %s: %v", run.Id, err)
+		}
+	})
+	if results, err := runStore.LoadAll(); err != nil {
+		panic(err)
+	} else {
+		for _, result := range results {
+			runManager.Restore(result.Run)
+		}
+	}
+	controlServer := runControlEndpoint(ctx, valueStore, valueHistory, metrics, latencyInjector, faultInjector, payloads, websockets, grpcSensor, shaper, runManager, registry.Metas, snapshot, resetAction, cfg)
+
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down, draining requests for up to %s", cfg.ShutdownTimeout.Duration())
+	shutdownServers(cfg.ShutdownTimeout.Duration(), sensorServer, controlServer)
+	grpcSensor.Stop(cfg.ShutdownTimeout.Duration())
+	if tcpSensor != nil {
+		tcpSensor.Close()
+	}
+	if udpSensor != nil {
+		udpSensor.Close()
+	}
+	valueStore.Close()
+	if requestRecorder != nil {
+		if err := requestRecorder.Close(); err != nil {
+			log.Printf("error closing recording: %v", err)
+		}
+	}
 
-	select {}
+	if run, ok := runManager.Active(); ok {
+		if _, err := runManager.Stop(run.Id); err != nil {
+			log.Printf("error stopping run %s: %v", run.Id, err)
+		}
+	}
+	stoppedAt := time.Now()
+	if err := writeFinalSnapshot(cfg.FinalSnapshotFile(), FinalSnapshot{
+		StartedAt: startedAt,
+		StoppedAt: stoppedAt,
+		Metrics:   snapshot(),
+		History:   valueHistory.QueryAll(startedAt, stoppedAt),
+	}); err != nil {
+		log.Printf("error writing final metrics: %v", err)
+	} else {
+		log.Printf("final metrics written to %s", cfg.FinalSnapshotFile())
+	}
 }
 
-func runSensorEndpoint[T any](sessionStore *session.Store[T], listener *connection.CountingListener, valueStore *store.Store) {
+// runNetSensors startet die optionalen TCP- und UDP-Sensoren; nicht konfigurierte Sensoren sind nil
+func runNetSensors(registry *metric.Registry, tcpConfig netsensor.TCPConfig, udpConfig netsensor.UDPConfig, shaper *connection.Shaper) (tcpSensor *netsensor.TCPSensor, udpSensor *netsensor.UDPSensor) {
+	if tcpConfig.Addr != "" {
+		listener := createListener("tcp", tcpConfig.Addr,
+			registry.Counter("tcp.bytes.read.count", metric.Meta{Unit: metric.UnitBytes, Description: "bytes read on raw tcp connections"}),
+			registry.Counter("tcp.bytes.write.count", metric.Meta{Unit: metric.UnitBytes, Description: "bytes written on raw tcp connections"}), shaper)
+		tcpSensor = netsensor.NewTCPSensor(listener, tcpConfig.Mode, registry)
+		log.Printf("start tcp sensor on %s", listener.Addr().String())
+		go func() {
+			if err := tcpSensor.Serve(); err != nil {
+				log.Fatalf("tcp sensor failed: %v", err)
+			}
+		}()
+	}
+	if udpConfig.Addr != "" {
+		conn, err := net.ListenPacket("udp", udpConfig.Addr)
+		if err != nil {
+			panic(err)
+		}
+		udpSensor = netsensor.NewUDPSensor(conn, udpConfig.Mode, registry)
+		log.Printf("start udp sensor on %s", conn.LocalAddr().String())
+		go func() {
+			if err := udpSensor.Serve(); err != nil {
+				log.Fatalf("udp sensor failed: %v", err)
+			}
+		}()
+	}
+	return tcpSensor, udpSensor
+}
+
+// Routen des Sensors, einmal kompiliert, da sie je Request geprüft werden
+var (
+	loginRoute    = utils.CompilePattern("POST::/login")
+	apiLoginRoute = utils.CompilePattern("POST::/api/login")
+	logoutRoute   = utils.CompilePattern("/logout")
+	echoRoute     = utils.CompilePattern("/echo")
+	echoAnyRoute  = utils.CompilePattern("/echo/**")
+	wsRoute       = utils.CompilePattern("GET::/ws")
+)
+
+// sensorHandler zählt jeden Request, wendet Latenz- und Fehlerregeln an und verteilt auf die Routen des Sensors
+func sensorHandler[T any](sessionStore *session.Store[T], m sensorMetrics, routes *utils.RouteKeys, latencyInjector *latency.Injector, faultInjector *fault.Injector, payloads *payload.Generator, websockets *ws.Handler, requestRecorder *recorder.Recorder) http.Handler {
 	login := LoginHandler(sessionStore, AuthenticateAny[T](), "sid", func() {
-		valueStore.Set("session.count", sessionStore.SessionCount())
+		m.sessions.Set(float64(sessionStore.SessionCount()))
+		m.sessionsCreated.Inc()
 	})
 	logout := LogoutHandler(sessionStore, "sid", func() {
-		valueStore.Set("session.count", sessionStore.SessionCount())
+		m.sessions.Set(float64(sessionStore.SessionCount()))
 	})
-	defaultHandler := DefaultHandler()
-	srv := &http.Server{
-		Addr: listener.Addr().String(),
-		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-			valueStore.Reduce("request.count", func(v any) any {
-				return v.(int) + 1
-			})
+	echo := EchoHandler()
+	defaultHandler := DefaultHandler(payloads)
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		writer := utils.NewStatusLoggingResponseWriter(w)
+		responds := true
+		var body *recorder.DigestReader
+		if requestRecorder != nil {
+			body = recorder.NewDigestReader(request.Body)
+			request.Body = body
+		}
+		defer func() {
+			duration := time.Since(start)
+			m.latencies.Record(duration.Nanoseconds())
+			if responds {
+				m.statuses.Inc(strconv.Itoa(writer.Status))
+				m.classes.Inc(fmt.Sprintf("%dxx", writer.Status/100))
+			}
+			if requestRecorder != nil {
+				status := writer.Status
+				if !responds {
+					status = 0
+				}
+				recordRequest(requestRecorder, request, writer.Header(), status, body, start, duration)
+			}
+		}()
+		m.requests.Inc()
+		if conn, ok := connection.FromContext(request.Context()); ok {
+			conn.CountRequest()
+		}
+		m.routes.Inc(routes.Key(request))
+		m.protocols.Inc(protocolName(request))
 
-			if utils.Match("POST::/login", request) {
-				login(writer, request)
-			} else if utils.Match("POST::/api/login", request) {
-				login(writer, request)
-			} else if utils.Match("/logout", request) {
-				logout(writer, request)
-			} else {
-				defaultHandler(writer, request)
+		if err := latencyInjector.Wait(request); err != nil {
+			writer.Status = statusClientClosedRequest
+			return
+		}
+
+		if rule, ok := faultInjector.Pick(request); ok {
+			m.faults.Inc()
+			m.faultRules.Inc(rule.Name())
+			responds = rule.Responds()
+			fault.Apply(writer, request, rule)
+			return
+		}
+
+		if loginRoute.Match(request) {
+			login(writer, request)
+		} else if apiLoginRoute.Match(request) {
+			login(writer, request)
+		} else if logoutRoute.Match(request) {
+			logout(writer, request)
+		} else if echoRoute.Match(request) || echoAnyRoute.Match(request) {
+			echo(writer, request)
+		} else if wsRoute.Match(request) {
+			if websockets.Upgrade(writer, request) {
+				writer.Status = http.StatusSwitchingProtocols
 			}
-		}),
+		} else {
+			defaultHandler(writer, request)
+		}
+	})
+}
+
+func runSensorEndpoint[T any](sessionStore *session.Store[T], listener *connection.CountingListener, m sensorMetrics, routes *utils.RouteKeys, latencyInjector *latency.Injector, faultInjector *fault.Injector, payloads *payload.Generator, websockets *ws.Handler, requestRecorder *recorder.Recorder, tlsConfig *tls.Config, protocols *http.Protocols, idleTimeout time.Duration) *http.Server {
+	srv := &http.Server{
+		Addr:        listener.Addr().String(),
+		TLSConfig:   tlsConfig,
+		Protocols:   protocols,
+		IdleTimeout: idleTimeout,
+		ConnContext: connection.NewContext,
+		Handler:     sensorHandler(sessionStore, m, routes, latencyInjector, faultInjector, payloads, websockets, requestRecorder),
 	}
-	log.Printf("start http sensor-endpoint on %s", listener.Addr().String())
-	srv.Serve(listener)
+	srv.RegisterOnShutdown(websockets.Close)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("start https sensor-endpoint on %s", listener.Addr().String())
+			err = srv.ServeTLS(listener, "", "")
+		} else {
+			log.Printf("start http sensor-endpoint on %s", listener.Addr().String())
+			err = srv.Serve(listener)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("sensor-endpoint failed: %v", err)
+		}
+	}()
+	return srv
 }
 
-func runControlEndpoint(store *store.Store, resetAction Action, addr string) {
+// runControlEndpoint startet den Server im Hintergrund; Requests (v.a. Streams) enden, sobald ctx beendet ist
+func runControlEndpoint(ctx context.Context, store *store.Store, valueHistory *history.History, metrics http.HandlerFunc, latencyInjector *latency.Injector, faultInjector *fault.Injector, payloads *payload.Generator, websockets *ws.Handler, grpcSensor *grpcsensor.Sensor, shaper *connection.Shaper, runManager *runs.Manager, metas func() []metric.Meta, snapshot func() map[string]any, resetAction Action, cfg config.Config) *http.Server {
 
 	sessionKey := "sessid"
 	sessionStore := session.NewSessionStore[string]()
-	stream := requireSession(sessionStore, sessionKey, streamHandler(store))
+	stream := requireSession(sessionStore, sessionKey, streamHandler(store, metas, cfg.Control.StreamThrottle.Duration(), cfg.Control.PingInterval.Duration(), cfg.Control.StreamOverflow))
 	reset := requireSession(sessionStore, sessionKey, SimpleActionHandler(resetAction))
+	latencyConfig := requireSession(sessionStore, sessionKey, ConfigHandler(latencyInjector.Config, latencyInjector.SetConfig))
+	faultConfig := requireSession(sessionStore, sessionKey, ConfigHandler(faultInjector.Config, faultInjector.SetConfig))
+	payloadConfig := requireSession(sessionStore, sessionKey, ConfigHandler(payloads.Config, payloads.SetConfig))
+	websocketConfig := requireSession(sessionStore, sessionKey, ConfigHandler(websockets.Config, websockets.SetConfig))
+	grpcConfig := requireSession(sessionStore, sessionKey, ConfigHandler(grpcSensor.Config, grpcSensor.SetConfig))
+	shapingConfig := requireSession(sessionStore, sessionKey, ConfigHandler(shaper.Config, shaper.SetConfig))
+	historyHandler := requireSession(sessionStore, sessionKey, HistoryHandler(valueHistory))
+	startRun := requireSession(sessionStore, sessionKey, StartRunHandler(runManager))
+	stopRun := requireSession(sessionStore, sessionKey, StopRunHandler(runManager))
+	getRun := requireSession(sessionStore, sessionKey, GetRunHandler(runManager))
+	listRuns := requireSession(sessionStore, sessionKey, ListRunsHandler(runManager))
+	compareRuns := requireSession(sessionStore, sessionKey, CompareRunsHandler(runManager))
+	verifyRun := requireSession(sessionStore, sessionKey, VerifyRunHandler(runManager))
+	verifyCurrent := requireSession(sessionStore, sessionKey, VerifyHandler(snapshot))
 	logout := LogoutHandler(sessionStore, sessionKey, Noop())
 	systemInfo := SystemInfoHandler()
 
-	authenticator, err := FileBasedKeyAuthenticator("./data/auth.sec")
+	authenticator, err := FileBasedKeyAuthenticator(cfg.AuthFile())
 	if err != nil {
 		panic(err)
 	}
 
 	login := LoginHandler[string](sessionStore, authenticator, sessionKey, Noop())
-	static := http.FileServer(http.Dir("./static"))
+	static := http.FileServer(http.Dir(cfg.Control.StaticDir))
 
-	log.Printf("start http control-endpoint on %s", addr)
+	log.Printf("start http control-endpoint on %s", cfg.Control.Addr)
 
-	go http.ListenAndServe(addr, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	srv := &http.Server{
+		Addr:        cfg.Control.Addr,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	srv.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		if utils.Match("GET::/system-info", request) {
 			systemInfo(writer, request)
 		} else if utils.Match("POST::/auth", request) {
@@ -385,8 +987,44 @@ func runControlEndpoint(store *store.Store, resetAction Action, addr string) {
 			stream(writer, request)
 		} else if utils.Match("PATCH::/reset", request) {
 			reset(writer, request)
+		} else if utils.Match("POST::/runs", request) {
+			startRun(writer, request)
+		} else if utils.Match("GET::/runs", request) {
+			listRuns(writer, request)
+		} else if utils.Match("POST::/runs/*/stop", request) {
+			stopRun(writer, request)
+		} else if utils.Match("POST::/runs/*/verify", request) {
+			verifyRun(writer, request)
+		} else if utils.Match("POST::/verify", request) {
+			verifyCurrent(writer, request)
+		} else if utils.Match("GET::/runs/compare", request) {
+			compareRuns(writer, request)
+		} else if utils.Match("GET::/runs/*", request) {
+			getRun(writer, request)
+		} else if utils.Match("GET::/metrics", request) {
+			metrics(writer, request)
+		} else if utils.Match("GET::/history", request) {
+			historyHandler(writer, request)
+		} else if utils.Match("GET::/latency", request) || utils.Match("PUT::/latency", request) {
+			latencyConfig(writer, request)
+		} else if utils.Match("GET::/faults", request) || utils.Match("PUT::/faults", request) {
+			faultConfig(writer, request)
+		} else if utils.Match("GET::/payload", request) || utils.Match("PUT::/payload", request) {
+			payloadConfig(writer, request)
+		} else if utils.Match("GET::/websocket", request) || utils.Match("PUT::/websocket", request) {
+			websocketConfig(writer, request)
+		} else if utils.Match("GET::/grpc", request) || utils.Match("PUT::/grpc", request) {
+			grpcConfig(writer, request)
+		} else if utils.Match("GET::/shaping", request) || utils.Match("PUT::/shaping", request) {
+			shapingConfig(writer, request)
 		} else {
 			static.ServeHTTP(writer, request)
 		}
-	}))
+	})
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("control-endpoint failed: %v", err)
+		}
+	}()
+	return srv
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+
+	"github.com/mwildt/load-monitor/pkg/recorder"
+)
+
+// runReplay implementiert das Subkommando "replay": loadmonitor replay -file requests.jsonl -target http://host:8081
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := flags.String("file", "", "recorded JSONL file to replay")
+	target := flags.String("target", "", "base URL of the target, e.g. http://localhost:8081")
+	scale := flags.Float64("scale", 1, "factor applied to the recorded request intervals (0 = as fast as possible)")
+	flags.Parse(args)
+
+	if *file == "" || *target == "" {
+		flags.Usage()
+		return fmt.Errorf("file and target are required")
+	} else if *scale < 0 {
+		return fmt.Errorf("scale must not be negative")
+	}
+	targetUrl, err := url.Parse(*target)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := recorder.Replay(ctx, f, targetUrl, *scale, http.DefaultClient)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
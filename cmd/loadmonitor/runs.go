@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mwildt/load-monitor/pkg/runs"
+	"github.com/mwildt/load-monitor/pkg/utils"
+	"github.com/mwildt/load-monitor/pkg/verify"
+)
+
+// pathSegment liefert das index-te Segment des Pfads, z.B. für /runs/{id}/stop: 0 = "runs", 1 = id
+func pathSegment(request *http.Request, index int) string {
+	segments := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	if index < len(segments) {
+		return segments[index]
+	}
+	return ""
+}
+
+func sendRunError(writer http.ResponseWriter, request *http.Request, err error) {
+	if errors.Is(err, runs.ErrNotFound) {
+		utils.SendJson(writer, request, http.StatusNotFound, utils.ErrorMessage{Error: err.Error()})
+	} else if errors.Is(err, runs.ErrNotRunning) {
+		utils.SendJson(writer, request, http.StatusConflict, utils.ErrorMessage{Error: err.Error()})
+	} else {
+		utils.BadRequestError(writer, request, err)
+	}
+}
+
+func StartRunHandler(manager *runs.Manager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if startRequest, err := utils.ReadJsonBody[runs.StartRequest](request); err != nil {
+			utils.BadRequestError(writer, request, err)
+		} else if run, err := manager.Start(startRequest); err != nil {
+			sendRunError(writer, request, err)
+		} else {
+			utils.CreatedJson(writer, request, run)
+		}
+	}
+}
+
+func StopRunHandler(manager *runs.Manager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if run, err := manager.Stop(pathSegment(request, 1)); err != nil {
+			sendRunError(writer, request, err)
+		} else {
+			utils.OkJson(writer, request, run)
+		}
+	}
+}
+
+func GetRunHandler(manager *runs.Manager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if run, err := manager.Get(pathSegment(request, 1)); err != nil {
+			sendRunError(writer, request, err)
+		} else {
+			utils.OkJson(writer, request, run)
+		}
+	}
+}
+
+func ListRunsHandler(manager *runs.Manager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		utils.OkJson(writer, request, manager.List())
+	}
+}
+
+func sendComparison(writer http.ResponseWriter, request *http.Request, comparison runs.Comparison, format string) {
+	switch format {
+	case "md", "markdown":
+		writer.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		runs.RenderMarkdown(writer, comparison)
+	case "html":
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		runs.RenderHTML(writer, comparison)
+	default:
+		utils.OkJson(writer, request, comparison)
+	}
+}
+
+// CompareRunsHandler vergleicht zwei Runs: /runs/compare?a=<id>&b=<id>&format=json|md|html
+func CompareRunsHandler(manager *runs.Manager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		if a, err := manager.Get(query.Get("a")); err != nil {
+			sendRunError(writer, request, err)
+		} else if b, err := manager.Get(query.Get("b")); err != nil {
+			sendRunError(writer, request, err)
+		} else {
+			sendComparison(writer, request, runs.Compare(a, b), query.Get("format"))
+		}
+	}
+}
+
+func sendVerdict(writer http.ResponseWriter, request *http.Request, measured map[string]any) {
+	if verifyRequest, err := utils.ReadJsonBody[verify.Request](request); err != nil {
+		utils.BadRequestError(writer, request, err)
+	} else if err = verifyRequest.Validate(); err != nil {
+		utils.BadRequestError(writer, request, err)
+	} else {
+		utils.OkJson(writer, request, verify.Verify(measured, verifyRequest))
+	}
+}
+
+// VerifyRunHandler prüft den Report eines Lasttest-Tools gegen die Messwerte des Runs
+func VerifyRunHandler(manager *runs.Manager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if run, err := manager.Get(pathSegment(request, 1)); err != nil {
+			sendRunError(writer, request, err)
+		} else {
+			sendVerdict(writer, request, run.Metrics)
+		}
+	}
+}
+
+// VerifyHandler prüft den Report gegen die aktuellen Messwerte
+func VerifyHandler(snapshot func() map[string]any) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		sendVerdict(writer, request, snapshot())
+	}
+}
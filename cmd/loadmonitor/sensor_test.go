@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/broker"
+	"github.com/mwildt/load-monitor/pkg/config"
+	"github.com/mwildt/load-monitor/pkg/counter"
+	"github.com/mwildt/load-monitor/pkg/fault"
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/latency"
+	"github.com/mwildt/load-monitor/pkg/metric"
+	"github.com/mwildt/load-monitor/pkg/payload"
+	"github.com/mwildt/load-monitor/pkg/session"
+	"github.com/mwildt/load-monitor/pkg/store"
+	"github.com/mwildt/load-monitor/pkg/utils"
+	"github.com/mwildt/load-monitor/pkg/ws"
+)
+
+// BenchmarkSensorHandler vergleicht den Payload-Handler allein ("bare") mit dem vollständigen Sensor-Handler
+// ("instrumented": Zähler, Histogramm, Routen, Latenz- und Fehlerregeln). Ohne Netzwerk, damit der Overhead des
+// Monitors und nicht der Loopback gemessen wird. Ausführen mit: go test ./cmd/loadmonitor -run xxx -bench Sensor -cpu 1,4,8
+func BenchmarkSensorHandler(b *testing.B) {
+	cfg := config.Default()
+	payloads, err := payload.NewGenerator(cfg.Payload)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("bare", func(b *testing.B) {
+		benchmarkHandler(b, DefaultHandler(payloads))
+	})
+	b.Run("instrumented", func(b *testing.B) {
+		benchmarkHandler(b, newBenchmarkSensorHandler(b, cfg, payloads))
+	})
+}
+
+// newBenchmarkSensorHandler baut den Handler wie main: Zähler mit periodischem Flush, ein Subscriber am Store wie das
+// Dashboard und je eine Latenz- und Fehlerregel, die nicht greift, damit die Patterns geprüft werden
+func newBenchmarkSensorHandler(b *testing.B, cfg config.Config, payloads *payload.Generator) http.Handler {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	valueStore := store.NewStore(map[string]any{})
+	b.Cleanup(valueStore.Close)
+	registration, events, err := valueStore.RegisterThrottled(store.All(), cfg.Control.StreamThrottle.Duration(), broker.Coalesce)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { valueStore.Cancel(registration) })
+	go func() {
+		for range events {
+		}
+	}()
+	counters := counter.NewCounters()
+	go counters.Run(ctx, valueStore, cfg.CounterInterval.Duration())
+	registry := metric.NewRegistry(valueStore, counters, 1, 10, 60)
+	websockets, err := ws.NewHandler(cfg.WebSocket, registry, histogram.New(), histogram.New())
+	if err != nil {
+		b.Fatal(err)
+	}
+	latencyInjector, err := latency.NewInjector(latency.Config{
+		Routes: []latency.Route{{Pattern: "POST::/slow/**", Profile: latency.Profile{Type: latency.TypeFixed, Value: utils.Duration(time.Second)}}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	faultInjector, err := fault.NewInjector(fault.Config{
+		Rules: []fault.Rule{{Pattern: "/broken/*", Percentage: 100, Type: fault.TypeStatus, Status: http.StatusServiceUnavailable}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return sensorHandler(session.NewSessionStore[SensorSessionValue](), newSensorMetrics(registry), utils.NewRouteKeys(cfg.Sensor.RouteLimit),
+		latencyInjector, faultInjector, payloads, websockets, nil)
+}
+
+func benchmarkHandler(b *testing.B, handler http.Handler) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		request := httptest.NewRequest(http.MethodGet, "/api/items/42", nil)
+		for pb.Next() {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				b.Errorf("unexpected status %d", recorder.Code)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/history"
+)
+
+// FinalSnapshot wird beim Beenden des Prozesses geschrieben
+type FinalSnapshot struct {
+	SchemaVersion int                        `json:"schemaVersion"`
+	StartedAt     time.Time                  `json:"startedAt"`
+	StoppedAt     time.Time                  `json:"stoppedAt"`
+	Metrics       map[string]any             `json:"metrics"`
+	History       map[string][]history.Point `json:"history,omitempty"`
+}
+
+func writeFinalSnapshot(filename string, snapshot FinalSnapshot) error {
+	snapshot.SchemaVersion = 1
+	payload, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, payload, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// shutdownServers beendet alle Server parallel; was nach timeout noch läuft, wird hart geschlossen
+func shutdownServers(timeout time.Duration, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan struct{}, len(servers))
+	for _, srv := range servers {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			if err := srv.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("server on %s did not drain within %s, closing remaining connections", srv.Addr, timeout)
+				srv.Close()
+			} else if err != nil {
+				log.Printf("error shutting down server on %s: %v", srv.Addr, err)
+			}
+		}()
+	}
+	for range servers {
+		<-done
+	}
+}
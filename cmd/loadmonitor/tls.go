@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/certs"
+	"github.com/mwildt/load-monitor/pkg/config"
+	"github.com/mwildt/load-monitor/pkg/connection"
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/metric"
+)
+
+func sensorProtocols(sensor config.SensorConfig) *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(slices.Contains(sensor.Protocols, "http1"))
+	protocols.SetHTTP2(slices.Contains(sensor.Protocols, "h2"))
+	protocols.SetUnencryptedHTTP2(slices.Contains(sensor.Protocols, "h2c"))
+	return protocols
+}
+
+// sensorTLS liefert nil ohne TLS, ansonsten eine Konfiguration, deren Handshakes unter tls.* gezählt werden
+func sensorTLS(sensor config.SensorConfig, registry *metric.Registry, handshakes *histogram.Histogram) (*tls.Config, error) {
+	if !sensor.TLS.Enabled {
+		return nil, nil
+	}
+	certificate, err := certs.Load(sensor.TLS.CertFile, sensor.TLS.KeyFile, sensor.TLS.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	if sensor.TLS.CertFile == "" {
+		fingerprint := sha256.Sum256(certificate.Certificate[0])
+		log.Printf("generated self-signed sensor certificate for %v, sha256 %s", sensor.TLS.Hosts, hex.EncodeToString(fingerprint[:]))
+	}
+	// die Reihenfolge bestimmt die Präferenz bei der ALPN-Aushandlung
+	var nextProtos []string
+	if slices.Contains(sensor.Protocols, "h2") {
+		nextProtos = append(nextProtos, "h2")
+	}
+	if slices.Contains(sensor.Protocols, "http1") {
+		nextProtos = append(nextProtos, "http/1.1")
+	}
+	started := registry.Counter("tls.handshake.started.count", metric.Meta{Description: "started TLS handshakes"})
+	completed := registry.Counter("tls.handshake.count", metric.Meta{Description: "completed TLS handshakes"})
+	versions := registry.Family("tls.version.", metric.Meta{Label: "TLS Handshakes by Version", Description: "completed TLS handshakes by version"})
+	alpn := registry.Family("tls.alpn.", metric.Meta{Description: "completed TLS handshakes by negotiated protocol"})
+	return connection.InstrumentHandshakes(&tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   nextProtos,
+	}, func() {
+		started.Inc()
+	}, func(state tls.ConnectionState, duration time.Duration) {
+		handshakes.Record(duration.Nanoseconds())
+		completed.Inc()
+		versions.Inc(connection.VersionName(state.Version))
+		protocol := state.NegotiatedProtocol
+		if protocol == "" {
+			protocol = "none"
+		}
+		alpn.Inc(protocol)
+	}), nil
+}
+
+// protocolName unterscheidet HTTP/2 mit TLS (h2) und ohne (h2c)
+func protocolName(request *http.Request) string {
+	if request.ProtoMajor == 2 && request.TLS == nil {
+		return "h2c"
+	} else if request.ProtoMajor == 2 {
+		return "h2"
+	}
+	return "http1"
+}
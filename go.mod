@@ -2,4 +2,17 @@ module github.com/mwildt/load-monitor
 
 go 1.24.5
 
-require golang.org/x/crypto v0.42.0 // indirect
+require golang.org/x/crypto v0.42.0
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	google.golang.org/grpc v1.76.0
+	google.golang.org/protobuf v1.36.6
+)
+
+require (
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.36.0 // indirect
+	golang.org/x/text v0.29.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250804133106-a7a43d27e69b // indirect
+)
@@ -2,17 +2,65 @@ package broker
 
 import (
 	"crypto/rand"
+	"fmt"
 	"sync"
 )
 
+// Verhalten, wenn der Channel eines Subscribers voll ist
+const (
+	DropOldest = "drop-oldest"
+	DropNewest = "drop-newest"
+	// Coalesce ersetzt ältere Events mit demselben Key (Policy.Key) und verwirft erst danach die ältesten
+	Coalesce   = "coalesce"
+	Disconnect = "disconnect"
+
+	bufferSize = 1000
+)
+
 type (
 	RegistrationToken [32]byte
-	Broker[T any]     struct {
+	// Policy legt das Verhalten einer Registrierung fest, wenn der Subscriber nicht hinterherkommt
+	Policy[T any] struct {
+		Overflow string
+		Key      func(T) string
+	}
+	// Stats: Lagging sind Subscriber, deren Channel mindestens halb voll ist; die Zähler laufen seit dem Start
+	Stats struct {
+		Subscribers  int
+		Lagging      int
+		Dropped      int64
+		Coalesced    int64
+		Disconnected int64
+	}
+	subscription[T any] struct {
+		ch     chan T
+		policy Policy[T]
+	}
+	Broker[T any] struct {
 		mu      sync.Mutex
-		clients map[RegistrationToken]chan T
+		clients map[RegistrationToken]*subscription[T]
+		stats   Stats
 	}
 )
 
+func ValidateOverflow(overflow string) error {
+	switch overflow {
+	case DropOldest, DropNewest, Coalesce, Disconnect:
+		return nil
+	default:
+		return fmt.Errorf("unknown overflow policy %q, expected %s, %s, %s or %s", overflow, DropOldest, DropNewest, Coalesce, Disconnect)
+	}
+}
+
+func (p Policy[T]) Validate() error {
+	if err := ValidateOverflow(p.Overflow); err != nil {
+		return err
+	} else if p.Overflow == Coalesce && p.Key == nil {
+		return fmt.Errorf("overflow policy %s requires a key function", Coalesce)
+	}
+	return nil
+}
+
 func newRegistrationToken() (RegistrationToken, error) {
 	var b RegistrationToken
 	_, err := rand.Read(b[:])
@@ -24,36 +72,125 @@ func newRegistrationToken() (RegistrationToken, error) {
 
 func NewBroker[T comparable]() *Broker[T] {
 	return &Broker[T]{
-		clients: make(map[RegistrationToken]chan T),
+		clients: make(map[RegistrationToken]*subscription[T]),
 	}
 }
 
+// Register verwirft bei vollem Channel die ältesten Events
 func (b *Broker[T]) Register() (RegistrationToken, chan T, error) {
+	return b.RegisterWith(Policy[T]{Overflow: DropOldest})
+}
+
+func (b *Broker[T]) RegisterWith(policy Policy[T]) (RegistrationToken, chan T, error) {
+	if err := policy.Validate(); err != nil {
+		return RegistrationToken{}, nil, err
+	}
 	key, err := newRegistrationToken()
 	if err != nil {
 		return RegistrationToken{}, nil, err
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	ch := make(chan T, 1000)
-	b.clients[key] = ch
+	ch := make(chan T, bufferSize)
+	b.clients[key] = &subscription[T]{ch: ch, policy: policy}
 	return key, ch, nil
 }
 
 func (b *Broker[T]) Cancel(key RegistrationToken) {
-	ch, exists := b.clients[key]
-	if exists {
-		b.mu.Lock()
-		defer b.mu.Unlock()
-		close(ch)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if client, exists := b.clients[key]; exists {
+		close(client.ch)
+		delete(b.clients, key)
+	}
+}
+
+// Close beendet alle Registrierungen, die Channels der Clients werden geschlossen
+func (b *Broker[T]) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, client := range b.clients {
+		close(client.ch)
 		delete(b.clients, key)
 	}
 }
 
+// Broadcast blockiert nicht: ist der Channel eines Subscribers voll, entscheidet dessen Policy
 func (b *Broker[T]) Broadcast(msg T) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	for key, client := range b.clients {
+		select {
+		case client.ch <- msg:
+			continue
+		default:
+		}
+		switch client.policy.Overflow {
+		case DropNewest:
+			b.stats.Dropped++
+		case DropOldest:
+			b.dropOldest(client, msg)
+		case Coalesce:
+			b.coalesce(client, msg)
+		case Disconnect:
+			close(client.ch)
+			delete(b.clients, key)
+			b.stats.Disconnected++
+		}
+	}
+}
+
+// dropOldest: nur der Broker sendet, nach dem Entnehmen ist also Platz (der Subscriber liest höchstens mit)
+func (b *Broker[T]) dropOldest(client *subscription[T], msg T) {
+	select {
+	case <-client.ch:
+		b.stats.Dropped++
+	default:
+	}
+	client.ch <- msg
+}
+
+// coalesce leert den Channel und stellt je Key nur das neueste Event in der Reihenfolge des letzten Auftretens
+// wieder ein. Passen danach immer noch nicht alle hinein (mehr Keys als Platz), werden die ältesten verworfen.
+func (b *Broker[T]) coalesce(client *subscription[T], msg T) {
+	pending := make([]T, 0, cap(client.ch)+1)
+	for drained := false; !drained; {
+		select {
+		case event := <-client.ch:
+			pending = append(pending, event)
+		default:
+			drained = true
+		}
+	}
+	pending = append(pending, msg)
+	seen := make(map[string]bool, len(pending))
+	kept := make([]T, 0, len(pending))
+	for i := len(pending) - 1; i >= 0; i-- {
+		if key := client.policy.Key(pending[i]); !seen[key] {
+			seen[key] = true
+			kept = append(kept, pending[i])
+		}
+	}
+	b.stats.Coalesced += int64(len(pending) - len(kept))
+	// kept ist vom neuesten zum ältesten sortiert
+	if len(kept) > cap(client.ch) {
+		b.stats.Dropped += int64(len(kept) - cap(client.ch))
+		kept = kept[:cap(client.ch)]
+	}
+	for i := len(kept) - 1; i >= 0; i-- {
+		client.ch <- kept[i]
+	}
+}
+
+func (b *Broker[T]) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Subscribers = len(b.clients)
 	for _, client := range b.clients {
-		client <- msg
+		if len(client.ch) >= cap(client.ch)/2 {
+			stats.Lagging++
+		}
 	}
+	return stats
 }
@@ -0,0 +1,502 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/broker"
+	"github.com/mwildt/load-monitor/pkg/connection"
+	"github.com/mwildt/load-monitor/pkg/fault"
+	"github.com/mwildt/load-monitor/pkg/grpcsensor"
+	"github.com/mwildt/load-monitor/pkg/latency"
+	"github.com/mwildt/load-monitor/pkg/netsensor"
+	"github.com/mwildt/load-monitor/pkg/payload"
+	"github.com/mwildt/load-monitor/pkg/utils"
+	"github.com/mwildt/load-monitor/pkg/ws"
+)
+
+const envPrefix = "LOADMONITOR_"
+
+type (
+	Config struct {
+		DataDir string `json:"dataDir"`
+		// ShutdownTimeout begrenzt das Abarbeiten laufender Requests beim Beenden
+		ShutdownTimeout utils.Duration `json:"shutdownTimeout"`
+		// CounterInterval ist der Takt, in dem die Zähler des Sensors in den Store übertragen werden
+		CounterInterval utils.Duration      `json:"counterInterval"`
+		Sensor          SensorConfig        `json:"sensor"`
+		Control         ControlConfig       `json:"control"`
+		Record          RecordConfig        `json:"record"`
+		Latency         latency.Config      `json:"latency"`
+		Faults          fault.Config        `json:"faults"`
+		Payload         payload.Config      `json:"payload"`
+		WebSocket       ws.Config           `json:"websocket"`
+		TCP             netsensor.TCPConfig `json:"tcp"`
+		UDP             netsensor.UDPConfig `json:"udp"`
+		GRPC            GRPCConfig          `json:"grpc"`
+		// Shaping gilt für die Verbindungen aller Sensoren außer UDP
+		Shaping connection.ShapingConfig `json:"shaping"`
+	}
+	SensorConfig struct {
+		Addr       string `json:"addr"`
+		RouteLimit int    `json:"routeLimit"`
+		// IdleTimeout 0 lässt Keep-Alive-Verbindungen offen, bis der Client sie schließt
+		IdleTimeout utils.Duration `json:"idleTimeout,omitempty"`
+		// Protocols enthält http1, h2 (nur mit TLS) und/oder h2c (HTTP/2 ohne TLS, prior knowledge)
+		Protocols []string  `json:"protocols"`
+		TLS       TLSConfig `json:"tls"`
+	}
+	TLSConfig struct {
+		Enabled bool `json:"enabled"`
+		// ohne CertFile und KeyFile wird beim Start ein selbst signiertes Zertifikat für Hosts erzeugt
+		CertFile string   `json:"certFile,omitempty"`
+		KeyFile  string   `json:"keyFile,omitempty"`
+		Hosts    []string `json:"hosts,omitempty"`
+	}
+	ControlConfig struct {
+		Addr string `json:"addr"`
+		// AuthFile ist leer, wenn die Datei unter DataDir liegen soll
+		AuthFile       string         `json:"authFile,omitempty"`
+		StaticDir      string         `json:"staticDir"`
+		StreamThrottle utils.Duration `json:"streamThrottle"`
+		PingInterval   utils.Duration `json:"pingInterval"`
+		// StreamOverflow gilt für Streams, deren Client nicht hinterherkommt (überschreibbar per ?overflow=)
+		StreamOverflow string `json:"streamOverflow"`
+		MetricsToken   string `json:"metricsToken,omitempty"`
+	}
+	// GRPCConfig: ohne Addr wird kein gRPC-Sensor gestartet; Injection ist zur Laufzeit änderbar
+	GRPCConfig struct {
+		Addr      string            `json:"addr,omitempty"`
+		Injection grpcsensor.Config `json:"injection"`
+	}
+	RecordConfig struct {
+		File     string         `json:"file,omitempty"`
+		MaxSize  utils.ByteSize `json:"maxSize"`
+		MaxFiles int            `json:"maxFiles"`
+	}
+	// option verbindet ein Feld der Konfiguration mit Flag und Umgebungsvariable
+	option struct {
+		name  string
+		usage string
+		field func(*Config) any
+	}
+)
+
+var options = []option{
+	{"data-dir", "directory for the access secret and run results", func(c *Config) any { return &c.DataDir }},
+	{"shutdown-timeout", "maximum time to drain in-flight requests on SIGINT/SIGTERM", func(c *Config) any { return &c.ShutdownTimeout }},
+	{"counter-interval", "interval in which hot sensor counters are published to the store", func(c *Config) any { return &c.CounterInterval }},
+	{"sensor-addr", "listen address of the sensor endpoint", func(c *Config) any { return &c.Sensor.Addr }},
+	{"sensor-route-limit", "maximum number of distinct paths counted per route", func(c *Config) any { return &c.Sensor.RouteLimit }},
+	{"sensor-idle-timeout", "close idle keep-alive connections of the sensor endpoint after this time (0 = never)", func(c *Config) any { return &c.Sensor.IdleTimeout }},
+	{"sensor-protocols", "comma separated HTTP protocols of the sensor endpoint: http1, h2, h2c", func(c *Config) any { return &c.Sensor.Protocols }},
+	{"sensor-tls", "serve the sensor endpoint via TLS", func(c *Config) any { return &c.Sensor.TLS.Enabled }},
+	{"sensor-tls-cert", "PEM certificate file (self-signed if empty)", func(c *Config) any { return &c.Sensor.TLS.CertFile }},
+	{"sensor-tls-key", "PEM key file (self-signed if empty)", func(c *Config) any { return &c.Sensor.TLS.KeyFile }},
+	{"sensor-tls-hosts", "comma separated host names and IPs of the self-signed certificate", func(c *Config) any { return &c.Sensor.TLS.Hosts }},
+	{"tcp-addr", "listen address of the raw TCP sensor (disabled if empty)", func(c *Config) any { return &c.TCP.Addr }},
+	{"tcp-mode", "mode of the raw TCP sensor: echo, sink or source", func(c *Config) any { return &c.TCP.Mode }},
+	{"udp-addr", "listen address of the UDP sensor (disabled if empty)", func(c *Config) any { return &c.UDP.Addr }},
+	{"udp-mode", "mode of the UDP sensor: echo or sink", func(c *Config) any { return &c.UDP.Mode }},
+	{"grpc-addr", "listen address of the gRPC sensor (disabled if empty)", func(c *Config) any { return &c.GRPC.Addr }},
+	{"control-addr", "listen address of the control endpoint", func(c *Config) any { return &c.Control.Addr }},
+	{"control-auth-file", "file holding the access secret hash (default <data-dir>/auth.sec)", func(c *Config) any { return &c.Control.AuthFile }},
+	{"control-static-dir", "directory served as dashboard", func(c *Config) any { return &c.Control.StaticDir }},
+	{"control-stream-throttle", "minimum interval between stream updates of the same key", func(c *Config) any { return &c.Control.StreamThrottle }},
+	{"control-ping-interval", "interval of keep-alive pings on idle streams", func(c *Config) any { return &c.Control.PingInterval }},
+	{"control-stream-overflow", "policy for slow stream clients: drop-oldest, drop-newest, coalesce or disconnect", func(c *Config) any { return &c.Control.StreamOverflow }},
+	{"metrics-token", "bearer token required to scrape /metrics (optional)", func(c *Config) any { return &c.Control.MetricsToken }},
+	{"record", "append every sensor request to this JSONL file", func(c *Config) any { return &c.Record.File }},
+	{"record-max-size", "rotate the recording file after this size, e.g. 100MiB", func(c *Config) any { return &c.Record.MaxSize }},
+	{"record-max-files", "number of rotated recording files to keep", func(c *Config) any { return &c.Record.MaxFiles }},
+}
+
+func Default() Config {
+	return Config{
+		DataDir:         "./data",
+		ShutdownTimeout: utils.Duration(10 * time.Second),
+		CounterInterval: utils.Duration(100 * time.Millisecond),
+		Sensor: SensorConfig{
+			Addr:       ":8081",
+			RouteLimit: 100,
+			Protocols:  []string{"http1", "h2"},
+			TLS: TLSConfig{
+				Hosts: []string{"localhost", "127.0.0.1", "::1"},
+			},
+		},
+		Control: ControlConfig{
+			Addr:           ":8082",
+			StaticDir:      "./static",
+			StreamThrottle: utils.Duration(250 * time.Millisecond),
+			PingInterval:   utils.Duration(10 * time.Second),
+			StreamOverflow: broker.Coalesce,
+		},
+		Record: RecordConfig{
+			MaxSize:  100 << 20,
+			MaxFiles: 5,
+		},
+		WebSocket: ws.Config{
+			Mode:         ws.ModeEcho,
+			Rate:         10,
+			Size:         64,
+			PingInterval: utils.Duration(time.Second),
+		},
+	}
+}
+
+func (c Config) AuthFile() string {
+	if c.Control.AuthFile != "" {
+		return c.Control.AuthFile
+	}
+	return filepath.Join(c.DataDir, "auth.sec")
+}
+
+// EnvDataDir liefert das Datenverzeichnis aus der Umgebung (LOADMONITOR_DATA_DIR) bzw. den Default, für
+// Subkommandos ohne vollständige Konfiguration
+func EnvDataDir(getenv func(string) string) string {
+	if value := getenv(envName("data-dir")); value != "" {
+		return value
+	}
+	return Default().DataDir
+}
+
+func (c Config) RunsDir() string {
+	return filepath.Join(c.DataDir, "runs")
+}
+
+func (c Config) FinalSnapshotFile() string {
+	return filepath.Join(c.DataDir, "final-metrics.json")
+}
+
+// envName bildet z.B. "sensor-addr" auf LOADMONITOR_SENSOR_ADDR ab
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func setValue(field any, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*f = parsed
+	case *[]string:
+		*f = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*f = append(*f, item)
+			}
+		}
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*f = parsed
+	case *utils.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 250ms", value)
+		}
+		*f = utils.Duration(parsed)
+	case *utils.ByteSize:
+		parsed, err := utils.ParseByteSize(value)
+		if err != nil {
+			return err
+		}
+		*f = parsed
+	default:
+		return fmt.Errorf("unsupported option type %T", field)
+	}
+	return nil
+}
+
+func formatValue(field any) string {
+	switch f := field.(type) {
+	case *string:
+		return *f
+	case *bool:
+		return strconv.FormatBool(*f)
+	case *[]string:
+		return strings.Join(*f, ",")
+	case *int:
+		return strconv.Itoa(*f)
+	case *utils.Duration:
+		return f.Duration().String()
+	case *utils.ByteSize:
+		return strconv.FormatInt(int64(*f), 10)
+	default:
+		return ""
+	}
+}
+
+// flagValue nimmt den Wert eines Flags zunächst als Text auf, damit Flags erst nach Datei und Umgebung angewendet werden
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value = value
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+func readFile(filename string, config *Config) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// Load ermittelt die Konfiguration in der Reihenfolge Defaults < Datei (-config) < Umgebung (LOADMONITOR_*) < Flags
+func Load(args []string, getenv func(string) string) (Config, error) {
+	flags := flag.NewFlagSet("loadmonitor", flag.ContinueOnError)
+	configFile := flags.String("config", getenv(envPrefix+"CONFIG"), "JSON configuration file (env "+envPrefix+"CONFIG)")
+	values := make(map[string]*flagValue)
+	defaults := Default()
+	for _, o := range options {
+		field := o.field(&defaults)
+		_, isBool := field.(*bool)
+		values[o.name] = &flagValue{value: formatValue(field), isBool: isBool}
+		flags.Var(values[o.name], o.name, fmt.Sprintf("%s (env %s)", o.usage, envName(o.name)))
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	config := Default()
+	if *configFile != "" {
+		if err := readFile(*configFile, &config); err != nil {
+			return config, err
+		}
+	}
+	for _, o := range options {
+		if value := getenv(envName(o.name)); value != "" {
+			if err := setValue(o.field(&config), value); err != nil {
+				return config, fmt.Errorf("%s: %w", envName(o.name), err)
+			}
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, o := range options {
+			if o.name == f.Name && err == nil {
+				if setErr := setValue(o.field(&config), values[o.name].value); setErr != nil {
+					err = fmt.Errorf("-%s: %w", o.name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+func validateAddr(name string, addr string) error {
+	if _, port, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%s: invalid listen address %q: %w", name, addr, err)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("%s: invalid port %q", name, port)
+	}
+	return nil
+}
+
+// sameAddr prüft, ob zwei TCP-Listen-Adressen kollidieren: gleicher Port und ein Wildcard-Host (":8080",
+// "0.0.0.0:8080", "[::]:8080") oder Hosts mit gemeinsamer IP ("localhost:8080" und "127.0.0.1:8080")
+func sameAddr(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	numberA, errA := strconv.ParseUint(portA, 10, 16)
+	numberB, errB := strconv.ParseUint(portB, 10, 16)
+	if errA != nil || errB != nil {
+		return a == b
+	} else if numberA != numberB || numberA == 0 {
+		// Port 0 vergibt das System frei, das kollidiert nie
+		return false
+	} else if strings.EqualFold(hostA, hostB) {
+		return true
+	}
+	ipsA, ipsB := hostIPs(hostA), hostIPs(hostB)
+	if ipsA == nil || ipsB == nil {
+		return true
+	}
+	for _, ipA := range ipsA {
+		for _, ipB := range ipsB {
+			if ipA.Equal(ipB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostIPs liefert nil für einen Wildcard-Host (leer, 0.0.0.0, ::) und eine leere Liste für nicht auflösbare Namen
+func hostIPs(host string) []net.IP {
+	if host == "" {
+		return nil
+	} else if ip := net.ParseIP(host); ip != nil {
+		if ip.IsUnspecified() {
+			return nil
+		}
+		return []net.IP{ip}
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return []net.IP{}
+	}
+	return ips
+}
+
+func (c Config) validateProtocols() error {
+	if len(c.Sensor.Protocols) == 0 {
+		return fmt.Errorf("sensor.protocols must not be empty")
+	}
+	usable := false
+	for _, protocol := range c.Sensor.Protocols {
+		switch protocol {
+		case "http1":
+			usable = true
+		case "h2":
+			usable = usable || c.Sensor.TLS.Enabled
+		case "h2c":
+			usable = usable || !c.Sensor.TLS.Enabled
+		default:
+			return fmt.Errorf("sensor.protocols: unknown protocol %q, expected http1, h2 or h2c", protocol)
+		}
+	}
+	if !usable {
+		return fmt.Errorf("sensor.protocols: none of %v is usable with tls.enabled=%t", c.Sensor.Protocols, c.Sensor.TLS.Enabled)
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if err := validateAddr("sensor.addr", c.Sensor.Addr); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateAddr("control.addr", c.Control.Addr); err != nil {
+		errs = append(errs, err)
+	}
+	if sameAddr(c.Sensor.Addr, c.Control.Addr) {
+		errs = append(errs, fmt.Errorf("sensor.addr and control.addr must differ"))
+	}
+	if c.TCP.Addr != "" {
+		if err := validateAddr("tcp.addr", c.TCP.Addr); err != nil {
+			errs = append(errs, err)
+		} else if sameAddr(c.TCP.Addr, c.Sensor.Addr) || sameAddr(c.TCP.Addr, c.Control.Addr) {
+			errs = append(errs, fmt.Errorf("tcp.addr must differ from sensor.addr and control.addr"))
+		}
+	}
+	if c.GRPC.Addr != "" {
+		if err := validateAddr("grpc.addr", c.GRPC.Addr); err != nil {
+			errs = append(errs, err)
+		} else if sameAddr(c.GRPC.Addr, c.Sensor.Addr) || sameAddr(c.GRPC.Addr, c.Control.Addr) || sameAddr(c.GRPC.Addr, c.TCP.Addr) {
+			errs = append(errs, fmt.Errorf("grpc.addr must differ from sensor.addr, control.addr and tcp.addr"))
+		}
+	}
+	if err := c.GRPC.Injection.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("grpc.injection: %w", err))
+	}
+	if c.UDP.Addr != "" {
+		if err := validateAddr("udp.addr", c.UDP.Addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.TCP.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("tcp: %w", err))
+	}
+	if err := c.UDP.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("udp: %w", err))
+	}
+	if err := c.validateProtocols(); err != nil {
+		errs = append(errs, err)
+	}
+	if (c.Sensor.TLS.CertFile == "") != (c.Sensor.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("sensor.tls: certFile and keyFile must be set together"))
+	} else if c.Sensor.TLS.Enabled && c.Sensor.TLS.CertFile == "" && len(c.Sensor.TLS.Hosts) == 0 {
+		errs = append(errs, fmt.Errorf("sensor.tls.hosts must not be empty for a self-signed certificate"))
+	}
+	if c.Sensor.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("sensor.idleTimeout must not be negative"))
+	}
+	if c.Sensor.RouteLimit < 1 {
+		errs = append(errs, fmt.Errorf("sensor.routeLimit must be at least 1"))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, fmt.Errorf("dataDir must not be empty"))
+	}
+	if c.CounterInterval <= 0 {
+		errs = append(errs, fmt.Errorf("counterInterval must be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdownTimeout must be positive"))
+	}
+	if info, err := os.Stat(c.Control.StaticDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("control.staticDir: %q is not a directory", c.Control.StaticDir))
+	}
+	if c.Control.StreamThrottle <= 0 {
+		errs = append(errs, fmt.Errorf("control.streamThrottle must be positive"))
+	}
+	if c.Control.PingInterval <= 0 {
+		errs = append(errs, fmt.Errorf("control.pingInterval must be positive"))
+	}
+	if err := broker.ValidateOverflow(c.Control.StreamOverflow); err != nil {
+		errs = append(errs, fmt.Errorf("control.streamOverflow: %w", err))
+	}
+	if c.Record.MaxSize < 0 || c.Record.MaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("record.maxSize and record.maxFiles must not be negative"))
+	}
+	if err := c.Latency.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("latency: %w", err))
+	}
+	if err := c.Faults.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("faults: %w", err))
+	}
+	if err := c.Payload.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("payload: %w", err))
+	}
+	if err := c.Shaping.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("shaping: %w", err))
+	}
+	if err := c.WebSocket.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("websocket: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -1,37 +1,184 @@
 package connection
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Gründe, aus denen eine Verbindung beendet wurde
+const (
+	CloseClient  = "client"
+	CloseServer  = "server"
+	CloseTimeout = "timeout"
+	CloseError   = "error"
 )
 
 type IntConsumer func(int)
 
+type (
+	// CloseConsumer erhält die beendete Verbindung und den Grund (CloseClient, CloseServer, ...)
+	CloseConsumer func(conn *CountingConn, reason string)
+	contextKey    struct{}
+)
+
 type CountingConn struct {
 	net.Conn
 	readConsumer  IntConsumer
 	writeConsumer IntConsumer
+	closeConsumer CloseConsumer
+	opened        time.Time
+	requests      atomic.Int64
+	// reason ist der erste beobachtete Fehler beim Lesen/Schreiben; ohne Fehler hat der Server geschlossen
+	reason       atomic.Pointer[string]
+	readDeadline atomic.Int64
+	closeOnce    sync.Once
+	// shaper ist optional, die Buckets begrenzen die Rate dieser Verbindung
+	shaper      *Shaper
+	readBucket  bucket
+	writeBucket bucket
+}
+
+// NetConn liefert die unterliegende Verbindung (analog zu tls.Conn)
+func (c *CountingConn) NetConn() net.Conn {
+	return c.Conn
 }
 
-func (c *CountingConn) Read(b []byte) (int, error) {
-	n, err := c.Conn.Read(b)
+func (c *CountingConn) Read(b []byte) (n int, err error) {
+	if c.shaper != nil {
+		n, err = c.shapedRead(b)
+	} else {
+		n, err = c.Conn.Read(b)
+	}
 	if n > 0 {
 		c.readConsumer(n)
 	}
+	if err != nil {
+		c.observe(err)
+	}
 	return n, err
 }
 
-func (c *CountingConn) Write(b []byte) (int, error) {
-	n, err := c.Conn.Write(b)
+func (c *CountingConn) Write(b []byte) (n int, err error) {
+	if c.shaper != nil {
+		n, err = c.shapedWrite(b)
+	} else {
+		n, err = c.Conn.Write(b)
+	}
 	if n > 0 {
 		c.writeConsumer(n)
 	}
+	if err != nil {
+		c.observe(err)
+	}
 	return n, err
 }
 
+func (c *CountingConn) SetDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *CountingConn) SetReadDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *CountingConn) storeReadDeadline(t time.Time) {
+	if t.IsZero() {
+		c.readDeadline.Store(0)
+	} else {
+		c.readDeadline.Store(t.UnixNano())
+	}
+}
+
+// observe merkt sich den ersten Fehler als Grund für das spätere Schließen
+func (c *CountingConn) observe(err error) {
+	reason := CloseError
+	var netErr net.Error
+	if errors.Is(err, net.ErrClosed) {
+		return
+	} else if errors.Is(err, io.EOF) {
+		reason = CloseClient
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		// net/http bricht Hintergrund-Reads mit einer Deadline in der Vergangenheit ab, das ist kein Timeout
+		if c.readDeadline.Load() < c.opened.UnixNano() {
+			return
+		}
+		reason = CloseTimeout
+	}
+	c.reason.CompareAndSwap(nil, &reason)
+}
+
+func (c *CountingConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.closeConsumer != nil {
+			c.closeConsumer(c, c.CloseReason())
+		}
+	})
+	return err
+}
+
+// CloseReason liefert den bisher beobachteten Grund, ohne Fehler CloseServer
+func (c *CountingConn) CloseReason() string {
+	if reason := c.reason.Load(); reason != nil {
+		return *reason
+	}
+	return CloseServer
+}
+
+func (c *CountingConn) Lifetime() time.Duration {
+	return time.Since(c.opened)
+}
+
+// CountRequest wird vom Handler je Request auf dieser Verbindung aufgerufen, siehe FromContext
+func (c *CountingConn) CountRequest() int64 {
+	return c.requests.Add(1)
+}
+
+func (c *CountingConn) Requests() int64 {
+	return c.requests.Load()
+}
+
+// Unwrap sucht in z.B. einer tls.Conn die CountingConn
+func Unwrap(conn net.Conn) (*CountingConn, bool) {
+	for {
+		if counting, ok := conn.(*CountingConn); ok {
+			return counting, true
+		} else if wrapper, ok := conn.(interface{ NetConn() net.Conn }); ok {
+			conn = wrapper.NetConn()
+		} else {
+			return nil, false
+		}
+	}
+}
+
+// NewContext ist für http.Server.ConnContext gedacht, damit Handler die Verbindung erreichen
+func NewContext(ctx context.Context, conn net.Conn) context.Context {
+	if counting, ok := Unwrap(conn); ok {
+		return context.WithValue(ctx, contextKey{}, counting)
+	}
+	return ctx
+}
+
+func FromContext(ctx context.Context) (*CountingConn, bool) {
+	conn, ok := ctx.Value(contextKey{}).(*CountingConn)
+	return conn, ok
+}
+
 type CountingListener struct {
 	net.Listener
 	ReadConsumer  IntConsumer
 	WriteConsumer IntConsumer
+	// AcceptConsumer, CloseConsumer und Shaper sind optional
+	AcceptConsumer func(conn *CountingConn)
+	CloseConsumer  CloseConsumer
+	Shaper         *Shaper
 }
 
 func (l *CountingListener) Accept() (net.Conn, error) {
@@ -39,6 +186,16 @@ func (l *CountingListener) Accept() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	cc := &CountingConn{Conn: connection, readConsumer: l.ReadConsumer, writeConsumer: l.WriteConsumer}
+	cc := &CountingConn{
+		Conn:          connection,
+		readConsumer:  l.ReadConsumer,
+		writeConsumer: l.WriteConsumer,
+		closeConsumer: l.CloseConsumer,
+		opened:        time.Now(),
+		shaper:        l.Shaper,
+	}
+	if l.AcceptConsumer != nil {
+		l.AcceptConsumer(cc)
+	}
 	return cc, nil
 }
@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+// sliceDuration bestimmt die Größe der Teilstücke, in denen gedrosselt wird (Bytes je 1/10 Sekunde)
+const sliceDuration = 100 * time.Millisecond
+
+type (
+	// ShapingConfig simuliert langsame Netze. Raten sind Bytes pro Sekunde (z.B. "1MiB"), 0 = unbegrenzt.
+	// WriteLatency ± Jitter (gleichverteilt) verzögert jeden Write, MaxWriteSize zerlegt Writes in Teilstücke.
+	ShapingConfig struct {
+		ReadRate        utils.ByteSize `json:"readRate,omitempty"`
+		WriteRate       utils.ByteSize `json:"writeRate,omitempty"`
+		GlobalReadRate  utils.ByteSize `json:"globalReadRate,omitempty"`
+		GlobalWriteRate utils.ByteSize `json:"globalWriteRate,omitempty"`
+		WriteLatency    utils.Duration `json:"writeLatency,omitempty"`
+		Jitter          utils.Duration `json:"jitter,omitempty"`
+		MaxWriteSize    utils.ByteSize `json:"maxWriteSize,omitempty"`
+	}
+	// Shaper wird von allen Verbindungen eines oder mehrerer Listener geteilt, die globalen Raten gelten gemeinsam
+	Shaper struct {
+		config      atomic.Pointer[ShapingConfig]
+		globalRead  bucket
+		globalWrite bucket
+	}
+	// bucket verteilt Zeitfenster nacheinander: wer n Bytes überträgt, belegt n/rate Sekunden
+	bucket struct {
+		mu   sync.Mutex
+		next time.Time
+	}
+)
+
+func (c ShapingConfig) Validate() error {
+	if c.ReadRate < 0 || c.WriteRate < 0 || c.GlobalReadRate < 0 || c.GlobalWriteRate < 0 {
+		return fmt.Errorf("rates must not be negative")
+	} else if c.WriteLatency < 0 || c.Jitter < 0 {
+		return fmt.Errorf("writeLatency and jitter must not be negative")
+	} else if c.MaxWriteSize < 0 {
+		return fmt.Errorf("maxWriteSize must not be negative")
+	}
+	return nil
+}
+
+func NewShaper(config ShapingConfig) (*Shaper, error) {
+	shaper := &Shaper{}
+	return shaper, shaper.SetConfig(config)
+}
+
+func (s *Shaper) Config() ShapingConfig {
+	return *s.config.Load()
+}
+
+func (s *Shaper) SetConfig(config ShapingConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	s.config.Store(&config)
+	return nil
+}
+
+// take belegt das Zeitfenster für n Bytes und liefert die Wartezeit bis zu dessen Ende
+func (b *bucket) take(n int, rate utils.ByteSize) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	return b.next.Sub(now)
+}
+
+// chunk begrenzt size auf die Bytes, die bei den angegebenen Raten in sliceDuration übertragen werden
+func chunk(size int, rates ...utils.ByteSize) int {
+	for _, rate := range rates {
+		if rate > 0 {
+			size = min(size, max(1, int(int64(rate)*int64(sliceDuration)/int64(time.Second))))
+		}
+	}
+	return size
+}
+
+func (c *ShapingConfig) writeDelay() time.Duration {
+	delay := c.WriteLatency.Duration()
+	if c.Jitter > 0 {
+		delay += time.Duration(rand.Int64N(2*int64(c.Jitter)+1)) - c.Jitter.Duration()
+	}
+	return max(0, delay)
+}
+
+func (c *CountingConn) shapedRead(b []byte) (int, error) {
+	config := c.shaper.config.Load()
+	if size := chunk(len(b), config.ReadRate, config.GlobalReadRate); size < len(b) {
+		b = b[:size]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		time.Sleep(max(c.readBucket.take(n, config.ReadRate), c.shaper.globalRead.take(n, config.GlobalReadRate)))
+	}
+	return n, err
+}
+
+// shapedWrite schreibt b in Teilstücken; wie bei net.Conn wird alles geschrieben oder ein Fehler geliefert
+func (c *CountingConn) shapedWrite(b []byte) (int, error) {
+	config := c.shaper.config.Load()
+	if delay := config.writeDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+	written := 0
+	for written < len(b) {
+		size := chunk(len(b)-written, config.WriteRate, config.GlobalWriteRate)
+		if config.MaxWriteSize > 0 {
+			size = min(size, int(config.MaxWriteSize))
+		}
+		n, err := c.Conn.Write(b[written : written+size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		time.Sleep(max(c.writeBucket.take(n, config.WriteRate), c.shaper.globalWrite.take(n, config.GlobalWriteRate)))
+	}
+	return written, nil
+}
@@ -0,0 +1,51 @@
+package connection
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+type (
+	HandshakeStarted   func()
+	HandshakeCompleted func(state tls.ConnectionState, duration time.Duration)
+)
+
+// InstrumentHandshakes liefert eine Kopie von config, die Beginn (ClientHello) und erfolgreiches Ende jedes
+// Handshakes meldet. Die Differenz zwischen begonnenen und abgeschlossenen Handshakes sind Fehlschläge.
+func InstrumentHandshakes(config *tls.Config, started HandshakeStarted, completed HandshakeCompleted) *tls.Config {
+	base := config.Clone()
+	instrumented := config.Clone()
+	instrumented.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		start := time.Now()
+		started()
+		perConn := base.Clone()
+		verify := perConn.VerifyConnection
+		perConn.VerifyConnection = func(state tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(state); err != nil {
+					return err
+				}
+			}
+			completed(state, time.Since(start))
+			return nil
+		}
+		return perConn, nil
+	}
+	return instrumented
+}
+
+// VersionName liefert z.B. "1.3" für tls.VersionTLS13
+func VersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	default:
+		return "unknown"
+	}
+}
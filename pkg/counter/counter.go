@@ -0,0 +1,123 @@
+package counter
+
+import (
+	"context"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/store"
+)
+
+// cacheLine trennt die Shards, damit parallele Adds nicht um dieselbe Cache-Line konkurrieren (false sharing)
+const cacheLine = 64
+
+type (
+	shard struct {
+		value atomic.Int64
+		_     [cacheLine - 8]byte
+	}
+	// Counter ist ein über mehrere Shards verteilter Zähler: Add ist lock-frei, Load summiert alle Shards
+	Counter struct {
+		shards []shard
+		mask   uint32
+	}
+	// Counters hält Zähler je Store-Key und überträgt deren Stände periodisch in den Store. Damit kostet
+	// ein Add weder den Store-Lock noch einen Broadcast an die Subscriber.
+	Counters struct {
+		counters sync.Map
+		// published ist der zuletzt in den Store geschriebene Stand je Key, nur von Flush verwendet
+		mu        sync.Mutex
+		published map[string]int64
+	}
+)
+
+func New() *Counter {
+	shards := 1 << bits.Len(uint(runtime.GOMAXPROCS(0)-1))
+	return &Counter{
+		shards: make([]shard, shards),
+		mask:   uint32(shards - 1),
+	}
+}
+
+// Add wählt den Shard zufällig; rand.Uint32 nutzt einen Generator je P und ist selbst lock-frei
+func (c *Counter) Add(n int64) {
+	c.shards[rand.Uint32()&c.mask].value.Add(n)
+}
+
+func (c *Counter) Load() int64 {
+	var sum int64
+	for i := range c.shards {
+		sum += c.shards[i].value.Load()
+	}
+	return sum
+}
+
+func (c *Counter) Reset() {
+	for i := range c.shards {
+		c.shards[i].value.Store(0)
+	}
+}
+
+func NewCounters() *Counters {
+	return &Counters{published: make(map[string]int64)}
+}
+
+// Get liefert den Zähler für key und legt ihn bei Bedarf an
+func (c *Counters) Get(key string) *Counter {
+	if counter, ok := c.counters.Load(key); ok {
+		return counter.(*Counter)
+	}
+	counter, _ := c.counters.LoadOrStore(key, New())
+	return counter.(*Counter)
+}
+
+func (c *Counters) Add(key string, n int64) {
+	c.Get(key).Add(n)
+}
+
+// Flush schreibt alle seit dem letzten Flush geänderten Zähler als int64 in den Store. Zähler, die noch
+// nie von 0 abgewichen sind, werden nicht geschrieben (der Store kennt sie über seine Defaults oder gar nicht).
+func (c *Counters) Flush(valueStore *store.Store) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters.Range(func(key, counter any) bool {
+		value := counter.(*Counter).Load()
+		if c.published[key.(string)] != value {
+			valueStore.Set(key.(string), value)
+			c.published[key.(string)] = value
+		}
+		return true
+	})
+}
+
+// Reset setzt alle Zähler auf 0 und valueStore zurück. Beides geschieht unter dem Lock von Flush, sonst könnte ein
+// Flush dazwischen die alten Stände in den geleerten Store schreiben. Die Zähler selbst bleiben erhalten, damit von
+// Get zwischengespeicherte Zeiger gültig bleiben.
+func (c *Counters) Reset(valueStore *store.Store) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters.Range(func(key, counter any) bool {
+		counter.(*Counter).Reset()
+		return true
+	})
+	c.published = make(map[string]int64)
+	valueStore.Reset()
+}
+
+// Run überträgt die Zähler alle interval in den Store, bis ctx beendet ist
+func (c *Counters) Run(ctx context.Context, valueStore *store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Flush(valueStore)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package counter
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/broker"
+	"github.com/mwildt/load-monitor/pkg/connection"
+	"github.com/mwildt/load-monitor/pkg/store"
+)
+
+// Die Benchmarks vergleichen den bisherigen Weg über store.Reduce (Store-Lock + Broadcast je Aufruf) mit den
+// Zählern. Ausführen mit: go test ./pkg/counter -run xxx -bench . -cpu 1,4,8
+
+// accounting ist die Buchhaltung des Sensors: Bytes je Read/Write der Verbindung, dazu je Request die
+// Request-, Routen-, Protokoll- und Statuszähler
+type accounting struct {
+	read    func(n int)
+	written func(n int)
+	request func()
+}
+
+func (a accounting) all(read, written int) {
+	a.read(read)
+	a.request()
+	a.written(written)
+}
+
+func reduceAccounting(valueStore *store.Store) accounting {
+	add := func(key string, n int64) {
+		valueStore.Reduce(key, func(v any) any {
+			if v == nil {
+				return n
+			}
+			return v.(int64) + n
+		})
+	}
+	return accounting{
+		read:    func(n int) { add("bytes.read.count", int64(n)) },
+		written: func(n int) { add("bytes.write.count", int64(n)) },
+		request: func() {
+			add("request.count", 1)
+			add("request.count.GET./", 1)
+			add("request.protocol.HTTP/1.1", 1)
+			add("response.status.200", 1)
+			add("response.class.2xx", 1)
+		},
+	}
+}
+
+func counterAccounting(counters *Counters) accounting {
+	read := counters.Get("bytes.read.count")
+	written := counters.Get("bytes.write.count")
+	return accounting{
+		read:    func(n int) { read.Add(int64(n)) },
+		written: func(n int) { written.Add(int64(n)) },
+		request: func() {
+			counters.Add("request.count", 1)
+			counters.Add("request.count.GET./", 1)
+			counters.Add("request.protocol.HTTP/1.1", 1)
+			counters.Add("response.status.200", 1)
+			counters.Add("response.class.2xx", 1)
+		},
+	}
+}
+
+// subscribe hält wie das Dashboard einen Subscriber am Store, damit jeder Broadcast auch zugestellt werden muss
+func subscribe(b *testing.B, valueStore *store.Store) {
+	registration, events, err := valueStore.Register(store.All(), broker.DropOldest)
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		for range events {
+		}
+	}()
+	b.Cleanup(func() { valueStore.Cancel(registration) })
+}
+
+func newStore(b *testing.B) *store.Store {
+	valueStore := store.NewStore(map[string]any{})
+	subscribe(b, valueStore)
+	b.Cleanup(valueStore.Close)
+	return valueStore
+}
+
+// newCounters startet den periodischen Flush wie in main, damit dessen Kosten mitgemessen werden
+func newCounters(b *testing.B, valueStore *store.Store) *Counters {
+	counters := NewCounters()
+	done := make(chan struct{})
+	ticker := time.NewTicker(100 * time.Millisecond)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				counters.Flush(valueStore)
+			}
+		}
+	}()
+	b.Cleanup(func() { close(done) })
+	return counters
+}
+
+func reportRate(b *testing.B, unit string) {
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), unit)
+}
+
+func BenchmarkCounterAdd(b *testing.B) {
+	counter := New()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			counter.Add(1)
+		}
+	})
+	reportRate(b, "adds/s")
+	if counter.Load() != int64(b.N) {
+		b.Fatalf("expected %d, got %d", b.N, counter.Load())
+	}
+}
+
+func BenchmarkCountersAdd(b *testing.B) {
+	counters := NewCounters()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			counters.Add("request.count", 1)
+		}
+	})
+	reportRate(b, "adds/s")
+}
+
+func BenchmarkStoreReduce(b *testing.B) {
+	valueStore := newStore(b)
+	add := reduceAccounting(valueStore)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			add.all(128, 256)
+		}
+	})
+	reportRate(b, "req/s")
+}
+
+func BenchmarkCounters(b *testing.B) {
+	valueStore := newStore(b)
+	add := counterAccounting(newCounters(b, valueStore))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			add.all(128, 256)
+		}
+	})
+	reportRate(b, "req/s")
+}
+
+// BenchmarkHTTP misst Requests über Loopback mit Keep-Alive durch einen CountingListener. Die Differenz zwischen
+// "none" und den beiden anderen Varianten ist der Overhead des Monitors bei voller Last.
+func BenchmarkHTTP(b *testing.B) {
+	variants := []struct {
+		name  string
+		setup func(b *testing.B) accounting
+	}{
+		{"none", func(*testing.B) accounting { return accounting{func(int) {}, func(int) {}, func() {}} }},
+		{"store", func(b *testing.B) accounting { return reduceAccounting(newStore(b)) }},
+		{"counters", func(b *testing.B) accounting {
+			valueStore := newStore(b)
+			return counterAccounting(newCounters(b, valueStore))
+		}},
+	}
+	for _, variant := range variants {
+		b.Run(variant.name, func(b *testing.B) {
+			benchmarkHTTP(b, variant.setup(b))
+		})
+	}
+}
+
+func benchmarkHTTP(b *testing.B, add accounting) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	listener := &connection.CountingListener{
+		Listener:      ln,
+		ReadConsumer:  add.read,
+		WriteConsumer: add.written,
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		add.request()
+		writer.Write([]byte("ok"))
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	transport := &http.Transport{MaxIdleConnsPerHost: 1024}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			response, err := client.Get(server.URL)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+	})
+	reportRate(b, "req/s")
+}
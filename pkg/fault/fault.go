@@ -0,0 +1,199 @@
+package fault
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+const (
+	TypeStatus       = "status"
+	TypeReset        = "reset"
+	TypeHang         = "hang"
+	TypeCloseMidBody = "close-mid-body"
+
+	defaultBodySize = 1024
+)
+
+type (
+	// Rule löst für Requests auf Pattern mit der Wahrscheinlichkeit Percentage (0..100) einen Fehler aus
+	Rule struct {
+		Pattern    string         `json:"pattern"`
+		Percentage float64        `json:"percentage"`
+		Type       string         `json:"type"`
+		Status     int            `json:"status,omitempty"`
+		RetryAfter utils.Duration `json:"retryAfter,omitempty"`
+		Duration   utils.Duration `json:"duration,omitempty"`
+		BodySize   int            `json:"bodySize,omitempty"`
+		// pattern wird in SetConfig kompiliert
+		pattern *utils.Pattern
+	}
+	Config struct {
+		Rules []Rule `json:"rules"`
+	}
+	Injector struct {
+		config atomic.Pointer[Config]
+	}
+)
+
+func (r Rule) Validate() error {
+	if r.Pattern == "" {
+		return fmt.Errorf("pattern must not be empty")
+	} else if r.Percentage < 0 || r.Percentage > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100")
+	}
+	switch r.Type {
+	case TypeStatus:
+		if r.Status < 100 || r.Status > 999 {
+			return fmt.Errorf("status: invalid status code %d", r.Status)
+		}
+	case TypeReset:
+	case TypeHang:
+		if r.Duration < 0 {
+			return fmt.Errorf("hang: duration must not be negative")
+		}
+	case TypeCloseMidBody:
+		if r.BodySize < 0 {
+			return fmt.Errorf("close-mid-body: bodySize must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown fault type %q", r.Type)
+	}
+	return nil
+}
+
+func (r Rule) matches(request *http.Request) bool {
+	if r.pattern != nil {
+		return r.pattern.Match(request)
+	}
+	return utils.Match(r.Pattern, request)
+}
+
+// Responds gibt an, ob der Fehler überhaupt einen Status an den Client sendet
+func (r Rule) Responds() bool {
+	return r.Type == TypeStatus || r.Type == TypeCloseMidBody
+}
+
+// Name liefert einen Bezeichner für Metriken, z.B. "status.503" oder "reset"
+func (r Rule) Name() string {
+	if r.Type == TypeStatus {
+		return fmt.Sprintf("%s.%d", r.Type, r.Status)
+	}
+	return r.Type
+}
+
+func (c Config) Validate() error {
+	for i, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func NewInjector(config Config) (*Injector, error) {
+	injector := &Injector{}
+	return injector, injector.SetConfig(config)
+}
+
+func (i *Injector) Config() Config {
+	return *i.config.Load()
+}
+
+func (i *Injector) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	config.Rules = slices.Clone(config.Rules)
+	for j := range config.Rules {
+		config.Rules[j].pattern = utils.CompilePattern(config.Rules[j].Pattern)
+	}
+	i.config.Store(&config)
+	return nil
+}
+
+// Pick würfelt für jede passende Regel in Reihenfolge; die erste ausgelöste Regel wird geliefert
+func (i *Injector) Pick(request *http.Request) (Rule, bool) {
+	for _, rule := range i.config.Load().Rules {
+		if rule.matches(request) && rand.Float64()*100 < rule.Percentage {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Apply führt den Fehler aus. Bei Fehlern auf Verbindungsebene wird der Handler per http.ErrAbortHandler abgebrochen.
+func Apply(writer http.ResponseWriter, request *http.Request, rule Rule) {
+	switch rule.Type {
+	case TypeStatus:
+		if rule.RetryAfter > 0 {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rule.RetryAfter.Duration().Seconds()))))
+		}
+		writer.WriteHeader(rule.Status)
+	case TypeReset:
+		reset(writer)
+	case TypeHang:
+		hang(request, rule.Duration.Duration())
+	case TypeCloseMidBody:
+		closeMidBody(writer, rule.BodySize)
+	}
+}
+
+// reset schließt die Verbindung mit SO_LINGER=0, sodass der Client ein RST statt FIN erhält
+func reset(writer http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(writer).Hijack()
+	if err != nil {
+		// z.B. HTTP/2: hier bleibt nur der Abbruch des Streams
+		panic(http.ErrAbortHandler)
+	}
+	setLingerZero(conn)
+	conn.Close()
+}
+
+func setLingerZero(conn net.Conn) {
+	for {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+			return
+		} else if wrapper, ok := conn.(interface{ NetConn() net.Conn }); ok {
+			conn = wrapper.NetConn()
+		} else {
+			return
+		}
+	}
+}
+
+// hang antwortet nie: es wird gewartet bis der Client aufgibt oder duration abläuft, danach wird die Verbindung verworfen
+func hang(request *http.Request, duration time.Duration) {
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-request.Context().Done():
+	case <-timeout:
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// closeMidBody kündigt bodySize Bytes an, sendet aber nur die Hälfte und bricht dann die Verbindung ab
+func closeMidBody(writer http.ResponseWriter, bodySize int) {
+	if bodySize == 0 {
+		bodySize = defaultBodySize
+	}
+	writer.Header().Set("Content-Length", strconv.Itoa(bodySize))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(make([]byte, bodySize/2))
+	http.NewResponseController(writer).Flush()
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,224 @@
+package grpcsensor
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/latency"
+	"github.com/mwildt/load-monitor/pkg/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// ErrorRule beendet Aufrufe von Method (kurzer oder voller Name, leer = alle) mit der Wahrscheinlichkeit
+	// Percentage (0..100) mit Code, z.B. "UNAVAILABLE" oder 14
+	ErrorRule struct {
+		Method     string  `json:"method,omitempty"`
+		Percentage float64 `json:"percentage"`
+		Code       string  `json:"code"`
+		Message    string  `json:"message,omitempty"`
+	}
+	Config struct {
+		Delay  latency.Profile `json:"delay"`
+		Errors []ErrorRule     `json:"errors,omitempty"`
+	}
+	Sensor struct {
+		config      atomic.Pointer[Config]
+		registry    *metric.Registry
+		latencies   map[string]*histogram.Histogram
+		server      *grpc.Server
+		calls       *metric.Counter
+		injected    *metric.Counter
+		messagesIn  *metric.Counter
+		messagesOut *metric.Counter
+		requests    *metric.Family
+		statuses    *metric.Family
+	}
+	// countingStream zählt die Nachrichten eines Streams
+	countingStream struct {
+		grpc.ServerStream
+		sensor *Sensor
+	}
+)
+
+func parseCode(value string) (codes.Code, error) {
+	var code codes.Code
+	if _, err := strconv.ParseUint(value, 10, 32); err == nil {
+		err = code.UnmarshalJSON([]byte(value))
+		return code, err
+	}
+	err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(value))))
+	return code, err
+}
+
+func (r ErrorRule) Validate() error {
+	if r.Percentage < 0 || r.Percentage > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100")
+	} else if code, err := parseCode(r.Code); err != nil {
+		return err
+	} else if code == codes.OK {
+		return fmt.Errorf("code must not be OK")
+	}
+	return nil
+}
+
+func (r ErrorRule) matches(fullMethod string) bool {
+	return r.Method == "" || r.Method == fullMethod || r.Method == path.Base(fullMethod)
+}
+
+func (c Config) Validate() error {
+	if err := c.Delay.Validate(); err != nil {
+		return fmt.Errorf("delay: %w", err)
+	}
+	for i, rule := range c.Errors {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("errors[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// New zählt unter grpc.* und zeichnet die Dauer der Aufrufe (bei Streams die Laufzeit) je Methode auf, siehe Latencies
+func New(config Config, registry *metric.Registry) (*Sensor, error) {
+	sensor := &Sensor{
+		registry:  registry,
+		latencies: make(map[string]*histogram.Histogram),
+	}
+	for _, method := range ServiceDesc.Methods {
+		sensor.latencies[method.MethodName] = histogram.New()
+	}
+	for _, stream := range ServiceDesc.Streams {
+		sensor.latencies[stream.StreamName] = histogram.New()
+	}
+	if err := sensor.SetConfig(config); err != nil {
+		return nil, err
+	}
+	sensor.server = grpc.NewServer(grpc.UnaryInterceptor(sensor.unary), grpc.StreamInterceptor(sensor.stream))
+	sensor.server.RegisterService(&ServiceDesc, sensorServer{})
+	return sensor, nil
+}
+
+// Latencies liefert die Histogramme der Aufrufdauer je Methode (kurzer Name, z.B. "Echo") in Nanosekunden
+func (s *Sensor) Latencies() map[string]*histogram.Histogram {
+	return s.latencies
+}
+
+func (s *Sensor) Config() Config {
+	return *s.config.Load()
+}
+
+func (s *Sensor) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	s.config.Store(&config)
+	return nil
+}
+
+// Serve registriert erst hier die Metriken, damit ein nicht gestarteter Sensor keine Keys im Store anlegt
+func (s *Sensor) Serve(listener net.Listener) error {
+	s.calls = s.registry.Counter("grpc.calls.count", metric.Meta{Description: "grpc calls including streams"})
+	s.injected = s.registry.Counter("grpc.injected.count", metric.Meta{Description: "grpc calls ended by an injected error"})
+	s.messagesIn = s.registry.Counter("grpc.messages.in.count", metric.Meta{Description: "received grpc messages"})
+	s.messagesOut = s.registry.Counter("grpc.messages.out.count", metric.Meta{Description: "sent grpc messages"})
+	s.requests = s.registry.Family("grpc.requests.", metric.Meta{Description: "grpc calls by method"})
+	s.statuses = s.registry.Family("grpc.status.", metric.Meta{Description: "grpc calls by status code"})
+	return s.server.Serve(listener)
+}
+
+// Stop wartet bis zu timeout auf laufende Aufrufe und bricht danach alle ab
+func (s *Sensor) Stop(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.server.Stop()
+	}
+}
+
+// inject zählt den Aufruf und wendet Verzögerung und Fehlerregeln an
+func (s *Sensor) inject(ctx context.Context, fullMethod string) error {
+	s.calls.Inc()
+	s.requests.Inc(path.Base(fullMethod))
+	config := s.config.Load()
+	if delay := config.Delay.Sample(); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	for _, rule := range config.Errors {
+		if rule.matches(fullMethod) && rand.Float64()*100 < rule.Percentage {
+			code, _ := parseCode(rule.Code)
+			s.injected.Inc()
+			message := rule.Message
+			if message == "" {
+				message = "injected by load-monitor"
+			}
+			return status.Error(code, message)
+		}
+	}
+	return nil
+}
+
+func (s *Sensor) finish(fullMethod string, start time.Time, err error) {
+	if latencies, exists := s.latencies[path.Base(fullMethod)]; exists {
+		latencies.Record(time.Since(start).Nanoseconds())
+	}
+	s.statuses.Inc(status.Code(err).String())
+}
+
+func (s *Sensor) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	defer func() { s.finish(info.FullMethod, start, err) }()
+	s.messagesIn.Inc()
+	if err = s.inject(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	if resp, err = handler(ctx, req); err == nil {
+		s.messagesOut.Inc()
+	}
+	return resp, err
+}
+
+func (s *Sensor) stream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	defer func() { s.finish(info.FullMethod, start, err) }()
+	if err = s.inject(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &countingStream{ServerStream: stream, sensor: s})
+}
+
+func (c *countingStream) RecvMsg(m any) error {
+	err := c.ServerStream.RecvMsg(m)
+	if err == nil {
+		c.sensor.messagesIn.Inc()
+	}
+	return err
+}
+
+func (c *countingStream) SendMsg(m any) error {
+	err := c.ServerStream.SendMsg(m)
+	if err == nil {
+		c.sensor.messagesOut.Inc()
+	}
+	return err
+}
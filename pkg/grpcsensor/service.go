@@ -0,0 +1,103 @@
+package grpcsensor
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const ServiceName = "loadmonitor.Sensor"
+
+type (
+	// SensorServer entspricht dem Dienst aus sensor.proto
+	SensorServer interface {
+		Echo(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+		Sink(context.Context, *wrapperspb.BytesValue) (*wrapperspb.Int64Value, error)
+		EchoStream(grpc.BidiStreamingServer[wrapperspb.BytesValue, wrapperspb.BytesValue]) error
+		SinkStream(grpc.ClientStreamingServer[wrapperspb.BytesValue, wrapperspb.Int64Value]) error
+	}
+	sensorServer struct{}
+)
+
+// ServiceDesc ist von Hand geschrieben, da nur Wrapper-Typen aus protobuf verwendet werden
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*SensorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Echo", Handler: unaryHandler("Echo", SensorServer.Echo)},
+		{MethodName: "Sink", Handler: unaryHandler("Sink", SensorServer.Sink)},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "EchoStream",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(SensorServer).EchoStream(&grpc.GenericServerStream[wrapperspb.BytesValue, wrapperspb.BytesValue]{ServerStream: stream})
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName: "SinkStream",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(SensorServer).SinkStream(&grpc.GenericServerStream[wrapperspb.BytesValue, wrapperspb.Int64Value]{ServerStream: stream})
+			},
+			ClientStreams: true,
+		},
+	},
+	Metadata: "sensor.proto",
+}
+
+// unaryHandler entspricht dem, was protoc-gen-go-grpc für eine unäre Methode erzeugt
+func unaryHandler[Req any, Res any](method string, call func(SensorServer, context.Context, *Req) (*Res, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(SensorServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}
+		return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+			return call(srv.(SensorServer), ctx, req.(*Req))
+		})
+	}
+}
+
+func (sensorServer) Echo(_ context.Context, in *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	return in, nil
+}
+
+func (sensorServer) Sink(_ context.Context, in *wrapperspb.BytesValue) (*wrapperspb.Int64Value, error) {
+	return wrapperspb.Int64(int64(len(in.GetValue()))), nil
+}
+
+func (sensorServer) EchoStream(stream grpc.BidiStreamingServer[wrapperspb.BytesValue, wrapperspb.BytesValue]) error {
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(in); err != nil {
+			return err
+		}
+	}
+}
+
+func (sensorServer) SinkStream(stream grpc.ClientStreamingServer[wrapperspb.BytesValue, wrapperspb.Int64Value]) error {
+	var received int64
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(wrapperspb.Int64(received))
+		} else if err != nil {
+			return err
+		}
+		received += int64(len(in.GetValue()))
+	}
+}
@@ -0,0 +1,162 @@
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// log-lineare Buckets (HDR-artig): unterhalb von subBucketCount exakt, darüber
+// pro Zweierpotenz subBucketHalf lineare Buckets -> relativer Fehler < 1%
+const (
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+	bucketCount    = subBucketCount + (63-subBucketBits)*subBucketHalf
+)
+
+type Histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	total  uint64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+func bucketIndex(value int64) int {
+	if value < subBucketCount {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - subBucketBits
+	return subBucketCount + (shift-1)*subBucketHalf + int(value>>shift) - subBucketHalf
+}
+
+// bucketValue liefert den größten Wert, der in den Bucket idx fällt
+func bucketValue(idx int) int64 {
+	if idx < subBucketCount {
+		return int64(idx)
+	}
+	shift := (idx-subBucketCount)/subBucketHalf + 1
+	mantissa := int64((idx-subBucketCount)%subBucketHalf + subBucketHalf)
+	return (mantissa+1)<<shift - 1
+}
+
+func New() *Histogram {
+	return &Histogram{
+		counts: make([]uint64, bucketCount),
+	}
+}
+
+func (h *Histogram) Record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[bucketIndex(value)]++
+	if h.total == 0 || value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+	h.total++
+	h.sum += value
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+func (h *Histogram) Min() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+func (h *Histogram) Mean() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.total)
+}
+
+// Quantile liefert den Wert, unter dem der Anteil q (0..1) aller Aufzeichnungen liegt
+func (h *Histogram) Quantile(q float64) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quantile(q)
+}
+
+// Quantiles berechnet mehrere Quantile unter einem Lock, damit die Werte konsistent sind
+func (h *Histogram) Quantiles(qs ...float64) []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]int64, len(qs))
+	for i, q := range qs {
+		res[i] = h.quantile(q)
+	}
+	return res
+}
+
+func (h *Histogram) quantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return h.max
+	}
+	// nearest rank: der kleinste Wert, bis zu dem mindestens q*total Aufzeichnungen reichen
+	target := max(1, uint64(math.Ceil(q*float64(h.total))))
+	var seen uint64
+	for idx, count := range h.counts {
+		seen += count
+		if seen >= target {
+			return min(max(bucketValue(idx), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *Histogram) Sum() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// CumulativeCounts liefert je Obergrenze die Anzahl der Aufzeichnungen <= bound (z.B. für Prometheus-Buckets)
+func (h *Histogram) CumulativeCounts(bounds []int64) []uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]uint64, len(bounds))
+	var seen uint64
+	idx := 0
+	for i, bound := range bounds {
+		for ; idx < len(h.counts) && bucketValue(idx) <= bound; idx++ {
+			seen += h.counts[idx]
+		}
+		res[i] = seen
+	}
+	return res
+}
+
+func (h *Histogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clear(h.counts)
+	h.total = 0
+	h.sum = 0
+	h.min = 0
+	h.max = 0
+}
@@ -0,0 +1,187 @@
+package history
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/store"
+)
+
+type (
+	// Point ist ein Messwert; Time in Unix-Millisekunden
+	Point struct {
+		Time  int64   `json:"time"`
+		Value float64 `json:"value"`
+	}
+	Resolution struct {
+		Interval  time.Duration
+		Retention time.Duration
+	}
+	// ring wächst bis size Punkte und überschreibt danach die ältesten
+	ring struct {
+		points []Point
+		size   int
+		next   int
+		full   bool
+	}
+	// History hält je Key und Auflösung einen Ringpuffer der letzten Werte
+	History struct {
+		mu          sync.RWMutex
+		resolutions []Resolution
+		series      map[string][]*ring
+		lastSample  []time.Time
+	}
+)
+
+func DefaultResolutions() []Resolution {
+	return []Resolution{
+		{Interval: time.Second, Retention: time.Hour},
+		{Interval: 10 * time.Second, Retention: 24 * time.Hour},
+	}
+}
+
+// minRingCapacity ist die Startkapazität eines Rings, kurzlebige Keys belegen so nicht die volle Vorhaltezeit
+const minRingCapacity = 64
+
+func newRing(size int) *ring {
+	return &ring{points: make([]Point, 0, min(size, minRingCapacity)), size: size}
+}
+
+func (r *ring) add(point Point) {
+	if !r.full {
+		if len(r.points) == cap(r.points) {
+			r.points = append(make([]Point, 0, min(r.size, 2*cap(r.points))), r.points...)
+		}
+		r.points = append(r.points, point)
+		r.full = len(r.points) == r.size
+		return
+	}
+	r.points[r.next] = point
+	r.next = (r.next + 1) % r.size
+}
+
+func (r *ring) between(from, to int64) []Point {
+	var ordered []Point
+	if r.full {
+		ordered = append(append(ordered, r.points[r.next:]...), r.points[:r.next]...)
+	} else {
+		ordered = r.points
+	}
+	start := sort.Search(len(ordered), func(i int) bool { return ordered[i].Time >= from })
+	end := sort.Search(len(ordered), func(i int) bool { return ordered[i].Time > to })
+	return append([]Point{}, ordered[start:end]...)
+}
+
+// New erwartet die Auflösungen aufsteigend sortiert (feinste zuerst)
+func New(resolutions ...Resolution) *History {
+	return &History{
+		resolutions: resolutions,
+		series:      make(map[string][]*ring),
+		lastSample:  make([]time.Time, len(resolutions)),
+	}
+}
+
+// Float konvertiert numerische Store-Werte; andere Typen werden nicht aufgezeichnet
+func Float(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// Sample übernimmt die Werte in alle Auflösungen, deren Intervall seit der letzten Übernahme abgelaufen ist.
+// Keys, die nicht mehr in values enthalten sind (z.B. nach einem Reset), werden verworfen.
+func (h *History) Sample(now time.Time, values map[string]any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.series {
+		if _, exists := values[key]; !exists {
+			delete(h.series, key)
+		}
+	}
+	for i, resolution := range h.resolutions {
+		if now.Sub(h.lastSample[i]) < resolution.Interval-resolution.Interval/10 {
+			continue
+		}
+		h.lastSample[i] = now
+		for key, value := range values {
+			if number, ok := Float(value); ok {
+				h.ring(key, i).add(Point{now.UnixMilli(), number})
+			}
+		}
+	}
+}
+
+func (h *History) ring(key string, resolution int) *ring {
+	rings, exists := h.series[key]
+	if !exists {
+		rings = make([]*ring, len(h.resolutions))
+		for i, r := range h.resolutions {
+			rings[i] = newRing(int(r.Retention / r.Interval))
+		}
+		h.series[key] = rings
+	}
+	return rings[resolution]
+}
+
+// Query liefert die Punkte im Zeitraum aus der feinsten Auflösung, deren Vorhaltezeit from noch abdeckt
+func (h *History) Query(key string, from, to time.Time) (Resolution, []Point) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	index := len(h.resolutions) - 1
+	for i, resolution := range h.resolutions {
+		if time.Since(from) <= resolution.Retention+resolution.Interval {
+			index = i
+			break
+		}
+	}
+	rings, exists := h.series[key]
+	if !exists {
+		return h.resolutions[index], []Point{}
+	}
+	return h.resolutions[index], rings[index].between(from.UnixMilli(), to.UnixMilli())
+}
+
+// QueryAll liefert den Verlauf aller Keys im Zeitraum
+func (h *History) QueryAll(from, to time.Time) map[string][]Point {
+	res := make(map[string][]Point)
+	for _, key := range h.Keys() {
+		_, res[key] = h.Query(key, from, to)
+	}
+	return res
+}
+
+func (h *History) Keys() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Run zeichnet die Werte des Stores im Takt der feinsten Auflösung auf, bis ctx beendet wird
+func (h *History) Run(ctx context.Context, valueStore *store.Store) {
+	ticker := time.NewTicker(h.resolutions[0].Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.Sample(now, valueStore.Entries())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package latency
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+const (
+	TypeNone    = "none"
+	TypeFixed   = "fixed"
+	TypeUniform = "uniform"
+	TypeNormal  = "normal"
+	TypeReplay  = "replay"
+)
+
+type (
+	Profile struct {
+		Type    string           `json:"type"`
+		Value   utils.Duration   `json:"value,omitempty"`
+		Min     utils.Duration   `json:"min,omitempty"`
+		Max     utils.Duration   `json:"max,omitempty"`
+		Mean    utils.Duration   `json:"mean,omitempty"`
+		StdDev  utils.Duration   `json:"stdDev,omitempty"`
+		Samples []utils.Duration `json:"samples,omitempty"`
+	}
+	Route struct {
+		Pattern string  `json:"pattern"`
+		Profile Profile `json:"profile"`
+		// pattern wird in SetConfig kompiliert
+		pattern *utils.Pattern
+	}
+	// Config enthält ein globales Profil und optionale Profile je utils.Match-Pattern; das erste passende gewinnt
+	Config struct {
+		Default Profile `json:"default"`
+		Routes  []Route `json:"routes"`
+	}
+	Injector struct {
+		config atomic.Pointer[Config]
+	}
+)
+
+func (p Profile) Validate() error {
+	switch p.Type {
+	case "", TypeNone:
+		return nil
+	case TypeFixed:
+		if p.Value < 0 {
+			return fmt.Errorf("fixed: value must not be negative")
+		}
+	case TypeUniform:
+		if p.Min < 0 || p.Max < p.Min {
+			return fmt.Errorf("uniform: expected 0 <= min <= max")
+		}
+	case TypeNormal:
+		if p.Mean < 0 || p.StdDev < 0 {
+			return fmt.Errorf("normal: mean and stdDev must not be negative")
+		}
+	case TypeReplay:
+		if len(p.Samples) == 0 {
+			return fmt.Errorf("replay: samples must not be empty")
+		}
+	default:
+		return fmt.Errorf("unknown profile type %q", p.Type)
+	}
+	return nil
+}
+
+// Sample zieht eine Verzögerung aus der Verteilung des Profils
+func (p Profile) Sample() time.Duration {
+	switch p.Type {
+	case TypeFixed:
+		return p.Value.Duration()
+	case TypeUniform:
+		if p.Max == p.Min {
+			return p.Min.Duration()
+		}
+		return p.Min.Duration() + rand.N(p.Max.Duration()-p.Min.Duration())
+	case TypeNormal:
+		return max(0, time.Duration(rand.NormFloat64()*float64(p.StdDev)+float64(p.Mean)))
+	case TypeReplay:
+		return p.Samples[rand.IntN(len(p.Samples))].Duration()
+	default:
+		return 0
+	}
+}
+
+func (c Config) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for _, route := range c.Routes {
+		if route.Pattern == "" {
+			return fmt.Errorf("route pattern must not be empty")
+		} else if err := route.Profile.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Pattern, err)
+		}
+	}
+	return nil
+}
+
+func (r Route) matches(request *http.Request) bool {
+	if r.pattern != nil {
+		return r.pattern.Match(request)
+	}
+	return utils.Match(r.Pattern, request)
+}
+
+func (c Config) Profile(request *http.Request) Profile {
+	for _, route := range c.Routes {
+		if route.matches(request) {
+			return route.Profile
+		}
+	}
+	return c.Default
+}
+
+func NewInjector(config Config) (*Injector, error) {
+	injector := &Injector{}
+	return injector, injector.SetConfig(config)
+}
+
+func (i *Injector) Config() Config {
+	return *i.config.Load()
+}
+
+func (i *Injector) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	config.Routes = slices.Clone(config.Routes)
+	for j := range config.Routes {
+		config.Routes[j].pattern = utils.CompilePattern(config.Routes[j].Pattern)
+	}
+	i.config.Store(&config)
+	return nil
+}
+
+func (i *Injector) Delay(request *http.Request) time.Duration {
+	return i.config.Load().Profile(request).Sample()
+}
+
+// Wait verzögert den Request gemäß Konfiguration; bricht der Client vorher ab, wird der Context-Fehler geliefert
+func (i *Injector) Wait(request *http.Request) error {
+	delay := i.Delay(request)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-request.Context().Done():
+		return request.Context().Err()
+	}
+}
@@ -0,0 +1,278 @@
+package metric
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/counter"
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/history"
+	"github.com/mwildt/load-monitor/pkg/rate"
+	"github.com/mwildt/load-monitor/pkg/store"
+)
+
+const (
+	KindCounter   = "counter"
+	KindGauge     = "gauge"
+	KindHistogram = "histogram"
+	KindMeter     = "meter"
+
+	UnitBytes        = "bytes"
+	UnitMilliseconds = "ms"
+	// UnitPerSecond ist die Einheit der Raten eines Meters ohne eigene Einheit
+	UnitPerSecond = "1/s"
+)
+
+type (
+	// Meta beschreibt einen Store-Key für Dashboard und Stream. Nur Metriken mit Label werden im Dashboard angezeigt;
+	// bei Prefix gilt die Beschreibung für alle Keys einer Familie, z.B. request.count.<route>
+	Meta struct {
+		Key         string `json:"key"`
+		Kind        string `json:"kind"`
+		Unit        string `json:"unit,omitempty"`
+		Label       string `json:"label,omitempty"`
+		Description string `json:"description,omitempty"`
+		Prefix      bool   `json:"prefix,omitempty"`
+	}
+	// Registry legt Metriken mit Default im Store an und hält deren Beschreibungen in Registrierungsreihenfolge
+	Registry struct {
+		valueStore *store.Store
+		counters   *counter.Counters
+		windows    []int
+		mu         sync.Mutex
+		metas      []Meta
+		index      map[string]int
+		rates      map[string]string
+	}
+	// Counter zählt lock-frei über counter.Counters, der Store sieht den Stand mit dem nächsten Flush
+	Counter struct {
+		key     string
+		counter *counter.Counter
+	}
+	// Family zählt unter <prefix><name> für zur Laufzeit bekannte Namen (Routen, Status, Gründe, ...)
+	Family struct {
+		prefix   string
+		counters *counter.Counters
+	}
+	Gauge struct {
+		key        string
+		valueStore *store.Store
+	}
+	// Histogram veröffentlicht Perzentile des Histogramms unter <prefix>.p50, ... geteilt durch scale
+	Histogram struct {
+		*histogram.Histogram
+		prefix     string
+		scale      float64
+		valueStore *store.Store
+	}
+	// Meter ist ein Counter, aus dem der rate.Sampler der Registry Raten unter <ratePrefix>.<fenster>s ableitet
+	Meter struct {
+		*Counter
+		ratePrefix string
+		valueStore *store.Store
+	}
+)
+
+var quantiles = []struct {
+	suffix   string
+	label    string
+	quantile float64
+}{
+	{"p50", "p50", 0.5},
+	{"p90", "p90", 0.9},
+	{"p99", "p99", 0.99},
+	{"p999", "p99.9", 0.999},
+	{"max", "max", 1},
+}
+
+// NewRegistry: windows sind die Fenster (in Sekunden) der Raten aller Meter
+func NewRegistry(valueStore *store.Store, counters *counter.Counters, windows ...int) *Registry {
+	return &Registry{
+		valueStore: valueStore,
+		counters:   counters,
+		windows:    windows,
+		index:      make(map[string]int),
+		rates:      make(map[string]string),
+	}
+}
+
+// describe ersetzt eine bereits registrierte Beschreibung desselben Keys, zero wird (außer bei nil) Default im Store
+func (r *Registry) describe(meta Meta, zero any) {
+	r.mu.Lock()
+	if i, exists := r.index[meta.Key]; exists {
+		r.metas[i] = meta
+	} else {
+		r.index[meta.Key] = len(r.metas)
+		r.metas = append(r.metas, meta)
+	}
+	r.mu.Unlock()
+	if zero != nil {
+		r.valueStore.SetDefault(meta.Key, zero)
+	}
+}
+
+// Metas liefert alle Beschreibungen in Registrierungsreihenfolge
+func (r *Registry) Metas() []Meta {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.metas)
+}
+
+func (r *Registry) Counter(key string, meta Meta) *Counter {
+	meta.Key, meta.Kind = key, KindCounter
+	r.describe(meta, int64(0))
+	return &Counter{key: key, counter: r.counters.Get(key)}
+}
+
+// Family registriert die Familie unter prefix; names werden mit 0 vorbelegt
+func (r *Registry) Family(prefix string, meta Meta, names ...string) *Family {
+	meta.Key, meta.Kind, meta.Prefix = prefix, KindCounter, true
+	r.describe(meta, nil)
+	for _, name := range names {
+		r.valueStore.SetDefault(prefix+name, int64(0))
+	}
+	return &Family{prefix: prefix, counters: r.counters}
+}
+
+func (r *Registry) Gauge(key string, meta Meta) *Gauge {
+	meta.Key, meta.Kind = key, KindGauge
+	r.describe(meta, 0.0)
+	return &Gauge{key: key, valueStore: r.valueStore}
+}
+
+// Histogram registriert die Perzentile von hist; bei UnitMilliseconds zeichnet hist Nanosekunden auf.
+// Nur die in display genannten Perzentile (z.B. "p50") erhalten ein Label.
+func (r *Registry) Histogram(prefix string, hist *histogram.Histogram, meta Meta, display ...string) *Histogram {
+	for _, q := range quantiles {
+		quantile := meta
+		quantile.Key, quantile.Kind = prefix+"."+q.suffix, KindHistogram
+		quantile.Label = ""
+		if meta.Label != "" && slices.Contains(display, q.suffix) {
+			quantile.Label = meta.Label + " " + q.label
+		}
+		if meta.Description != "" {
+			quantile.Description = fmt.Sprintf("%s (%s)", meta.Description, q.label)
+		}
+		r.describe(quantile, 0.0)
+	}
+	scale := 1.0
+	if meta.Unit == UnitMilliseconds {
+		scale = float64(time.Millisecond)
+	}
+	return &Histogram{Histogram: hist, prefix: prefix, scale: scale, valueStore: r.valueStore}
+}
+
+// Meter registriert den Zähler key mit meta (Label z.B. "Requests" ergibt "Total Requests") und dessen Raten.
+// Nur die Raten der in display genannten Fenster erhalten ein Label, z.B. "Requests/s" und "Requests/s (60s)".
+func (r *Registry) Meter(key string, ratePrefix string, meta Meta, display ...int) *Meter {
+	count := meta
+	if meta.Label != "" {
+		count.Label = "Total " + meta.Label
+	}
+	counter := r.Counter(key, count)
+	unit := UnitPerSecond
+	if meta.Unit != "" {
+		unit = meta.Unit + "/s"
+	}
+	for _, window := range r.windows {
+		rate := Meta{Key: fmt.Sprintf("%s.%ds", ratePrefix, window), Kind: KindMeter, Unit: unit}
+		if meta.Label != "" && slices.Contains(display, window) {
+			rate.Label = meta.Label + "/s"
+			if window != 1 {
+				rate.Label += fmt.Sprintf(" (%ds)", window)
+			}
+		}
+		if meta.Description != "" {
+			rate.Description = fmt.Sprintf("%s per second over %ds", meta.Description, window)
+		}
+		r.describe(rate, 0.0)
+	}
+	r.mu.Lock()
+	r.rates[key] = ratePrefix
+	r.mu.Unlock()
+	return &Meter{Counter: counter, ratePrefix: ratePrefix, valueStore: r.valueStore}
+}
+
+// Sampler liefert den rate.Sampler aller bis dahin registrierten Meter
+func (r *Registry) Sampler() *rate.Sampler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rates := make(map[string]string, len(r.rates))
+	for key, prefix := range r.rates {
+		rates[key] = prefix
+	}
+	return rate.NewSampler(rates, r.windows...)
+}
+
+func (c *Counter) Add(n int64) {
+	c.counter.Add(n)
+}
+
+func (c *Counter) Inc() {
+	c.counter.Add(1)
+}
+
+// Value liefert den aktuellen Stand, auch wenn er noch nicht in den Store übertragen wurde
+func (c *Counter) Value() int64 {
+	return c.counter.Load()
+}
+
+func (f *Family) Add(name string, n int64) {
+	f.counters.Add(f.prefix+name, n)
+}
+
+func (f *Family) Inc(name string) {
+	f.counters.Add(f.prefix+name, 1)
+}
+
+func (f *Family) Value(name string) int64 {
+	return f.counters.Get(f.prefix + name).Load()
+}
+
+func (g *Gauge) Set(value float64) {
+	g.valueStore.Set(g.key, value)
+}
+
+// Value liefert 0, falls der Key fehlt oder nicht numerisch ist
+func (g *Gauge) Value() float64 {
+	value, _ := history.Float(g.valueStore.Get(g.key))
+	return value
+}
+
+// Publish schreibt die aktuellen Perzentile in den Store
+func (h *Histogram) Publish() {
+	qs := make([]float64, len(quantiles))
+	for i, q := range quantiles {
+		qs[i] = q.quantile
+	}
+	for i, value := range h.Quantiles(qs...) {
+		h.valueStore.Set(h.prefix+"."+quantiles[i].suffix, float64(value)/h.scale)
+	}
+}
+
+// Run veröffentlicht die Perzentile alle interval, sofern neue Werte aufgezeichnet wurden
+func (h *Histogram) Run(ctx context.Context, interval time.Duration) {
+	var lastCount uint64
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if count := h.Count(); count != lastCount {
+				lastCount = count
+				h.Publish()
+			}
+		}
+	}
+}
+
+// Rate liefert die zuletzt veröffentlichte Rate für window (in Sekunden), 0 falls (noch) keine vorliegt
+func (m *Meter) Rate(window int) float64 {
+	value, _ := history.Float(m.valueStore.Get(fmt.Sprintf("%s.%ds", m.ratePrefix, window)))
+	return value
+}
@@ -0,0 +1,147 @@
+package netsensor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/metric"
+)
+
+const (
+	ModeEcho   = "echo"
+	ModeSink   = "sink"
+	ModeSource = "source"
+
+	sourceBlockSize = 32 << 10
+	// sourceEOFWait ist die Wartezeit auf das EOF des Lesers nach einem Schreibfehler
+	sourceEOFWait = time.Second
+)
+
+type (
+	// TCPConfig: ohne Addr wird kein TCP-Sensor gestartet
+	TCPConfig struct {
+		Addr string `json:"addr,omitempty"`
+		Mode string `json:"mode,omitempty"`
+	}
+	// TCPSensor bedient rohe TCP-Verbindungen: echo schickt alles zurück, sink verwirft, source sendet bis der Client schließt
+	TCPSensor struct {
+		listener    net.Listener
+		mode        string
+		mu          sync.Mutex
+		conns       map[net.Conn]struct{}
+		open        *metric.Gauge
+		connections *metric.Counter
+		errors      *metric.Counter
+	}
+)
+
+func (c TCPConfig) Validate() error {
+	switch c.Mode {
+	case "", ModeEcho, ModeSink, ModeSource:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q, expected echo, sink or source", c.Mode)
+	}
+}
+
+// NewTCPSensor registriert die Metriken unter tcp.*; Bytes zählt der übergebene (Counting-)Listener
+func NewTCPSensor(listener net.Listener, mode string, registry *metric.Registry) *TCPSensor {
+	if mode == "" {
+		mode = ModeEcho
+	}
+	return &TCPSensor{
+		listener:    listener,
+		mode:        mode,
+		conns:       make(map[net.Conn]struct{}),
+		open:        registry.Gauge("tcp.open", metric.Meta{Description: "open raw tcp connections"}),
+		connections: registry.Counter("tcp.connections.count", metric.Meta{Description: "accepted raw tcp connections"}),
+		errors:      registry.Counter("tcp.errors.count", metric.Meta{Description: "raw tcp connections ended with an error"}),
+	}
+}
+
+func (s *TCPSensor) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		s.track(conn, true)
+		s.connections.Inc()
+		go s.handle(conn)
+	}
+}
+
+func (s *TCPSensor) track(conn net.Conn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if open {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+	s.open.Set(float64(len(s.conns)))
+}
+
+func (s *TCPSensor) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.track(conn, false)
+	}()
+	var err error
+	switch s.mode {
+	case ModeEcho:
+		_, err = io.Copy(conn, conn)
+	case ModeSink:
+		_, err = io.Copy(io.Discard, conn)
+	case ModeSource:
+		err = s.source(conn)
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		s.errors.Inc()
+	}
+}
+
+// source sendet, bis der Client schließt. Eingaben werden verworfen; ein EOF beendet das Senden, Schreibfehler
+// danach (EPIPE, ECONNRESET) gehören zum regulären Schließen und sind kein Fehler.
+func (s *TCPSensor) source(conn net.Conn) error {
+	// read liefert das Ergebnis des Lesens, nil bei EOF
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, conn)
+		if err == nil {
+			conn.SetWriteDeadline(time.Now())
+		}
+		read <- err
+	}()
+	block := make([]byte, sourceBlockSize)
+	for {
+		if _, err := conn.Write(block); err != nil {
+			// der Schreibfehler kann vor dem EOF beim Leser ankommen
+			select {
+			case readErr := <-read:
+				if readErr == nil {
+					return nil
+				}
+			case <-time.After(sourceEOFWait):
+			}
+			return err
+		}
+	}
+}
+
+// Close schließt den Listener und alle offenen Verbindungen
+func (s *TCPSensor) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
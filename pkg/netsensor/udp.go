@@ -0,0 +1,154 @@
+package netsensor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/mwildt/load-monitor/pkg/metric"
+)
+
+const (
+	maxPacketSize = 64 << 10
+	// maxPeers begrenzt den Speicher für die Verlustberechnung; weitere Absender werden nur gezählt
+	maxPeers = 10000
+)
+
+type (
+	// UDPConfig: ohne Addr wird kein UDP-Sensor gestartet
+	UDPConfig struct {
+		Addr string `json:"addr,omitempty"`
+		Mode string `json:"mode,omitempty"`
+	}
+	// peer merkt sich je Absender die Sequenznummern; verloren sind alle Nummern zwischen erster und
+	// höchster, die (noch) nicht angekommen sind
+	peer struct {
+		first    uint64
+		highest  uint64
+		received uint64
+	}
+	// UDPSensor erwartet in den ersten 8 Byte jedes Pakets eine fortlaufende Sequenznummer (big endian)
+	UDPSensor struct {
+		conn        net.PacketConn
+		mode        string
+		mu          sync.Mutex
+		peers       map[string]*peer
+		lost        int64
+		packetsIn   *metric.Counter
+		packetsOut  *metric.Counter
+		bytesIn     *metric.Counter
+		bytesOut    *metric.Counter
+		unsequenced *metric.Counter
+		reordered   *metric.Counter
+		errors      *metric.Counter
+		peerCount   *metric.Gauge
+		lostCount   *metric.Gauge
+	}
+)
+
+func (c UDPConfig) Validate() error {
+	switch c.Mode {
+	case "", ModeEcho, ModeSink:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q, expected echo or sink", c.Mode)
+	}
+}
+
+func (p *peer) lost() int64 {
+	// Duplikate können received über die Spanne heben
+	return max(0, int64(p.highest-p.first+1)-int64(p.received))
+}
+
+// NewUDPSensor registriert die Metriken unter udp.*
+func NewUDPSensor(conn net.PacketConn, mode string, registry *metric.Registry) *UDPSensor {
+	if mode == "" {
+		mode = ModeEcho
+	}
+	return &UDPSensor{
+		conn:        conn,
+		mode:        mode,
+		peers:       make(map[string]*peer),
+		packetsIn:   registry.Counter("udp.packets.in.count", metric.Meta{Description: "received udp packets"}),
+		packetsOut:  registry.Counter("udp.packets.out.count", metric.Meta{Description: "echoed udp packets"}),
+		bytesIn:     registry.Counter("udp.bytes.in.count", metric.Meta{Unit: metric.UnitBytes, Description: "received udp bytes"}),
+		bytesOut:    registry.Counter("udp.bytes.out.count", metric.Meta{Unit: metric.UnitBytes, Description: "echoed udp bytes"}),
+		unsequenced: registry.Counter("udp.packets.unsequenced.count", metric.Meta{Description: "udp packets shorter than the 8 byte sequence number"}),
+		reordered:   registry.Counter("udp.packets.reordered.count", metric.Meta{Description: "udp packets received after a higher sequence number"}),
+		errors:      registry.Counter("udp.errors.count", metric.Meta{Description: "failed udp echo writes"}),
+		peerCount:   registry.Gauge("udp.peers", metric.Meta{Description: "udp senders with tracked sequence numbers"}),
+		lostCount:   registry.Gauge("udp.packets.lost", metric.Meta{Description: "missing udp sequence numbers over all senders"}),
+	}
+}
+
+func (s *UDPSensor) Serve() error {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		s.packetsIn.Inc()
+		s.bytesIn.Add(int64(n))
+		if n >= 8 {
+			s.sequence(addr.String(), binary.BigEndian.Uint64(buffer[:8]))
+		} else {
+			s.unsequenced.Inc()
+		}
+		if s.mode == ModeEcho {
+			if written, err := s.conn.WriteTo(buffer[:n], addr); err != nil {
+				s.errors.Inc()
+			} else {
+				s.packetsOut.Inc()
+				s.bytesOut.Add(int64(written))
+			}
+		}
+	}
+}
+
+func (s *UDPSensor) sequence(addr string, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, exists := s.peers[addr]
+	if !exists {
+		if len(s.peers) >= maxPeers {
+			return
+		}
+		s.peers[addr] = &peer{first: seq, highest: seq, received: 1}
+		s.peerCount.Set(float64(len(s.peers)))
+		return
+	}
+	before := p.lost()
+	if seq < p.first {
+		// Sequenz neu gestartet (z.B. neuer Testlauf vom selben Port)
+		s.lost -= before
+		*p = peer{first: seq, highest: seq, received: 1}
+		s.lostCount.Set(float64(s.lost))
+		return
+	} else if seq <= p.highest {
+		s.reordered.Inc()
+	} else {
+		p.highest = seq
+	}
+	p.received++
+	if after := p.lost(); after != before {
+		s.lost += after - before
+		s.lostCount.Set(float64(s.lost))
+	}
+}
+
+// Reset verwirft die Sequenznummern aller Absender
+func (s *UDPSensor) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers = make(map[string]*peer)
+	s.lost = 0
+}
+
+func (s *UDPSensor) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,159 @@
+package payload
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+const (
+	ContentZeros  = "zeros"
+	ContentRandom = "random"
+	ContentText   = "text"
+
+	TransferContentLength = "content-length"
+	TransferChunked       = "chunked"
+
+	blockSize        = 64 << 10
+	defaultChunkSize = 16 << 10
+)
+
+type (
+	// Config beschreibt den Body der Sensor-Antworten. Ist MaxSize größer als Size, wird die Größe
+	// gleichverteilt aus [Size, MaxSize] gewählt; mit QueryParam kann der Client die Größe selbst anfordern.
+	Config struct {
+		Size       utils.ByteSize `json:"size"`
+		MaxSize    utils.ByteSize `json:"maxSize,omitempty"`
+		QueryParam string         `json:"queryParam,omitempty"`
+		Limit      utils.ByteSize `json:"limit,omitempty"`
+		Content    string         `json:"content,omitempty"`
+		Transfer   string         `json:"transfer,omitempty"`
+		ChunkSize  utils.ByteSize `json:"chunkSize,omitempty"`
+	}
+	Generator struct {
+		config atomic.Pointer[Config]
+	}
+)
+
+var blocks = map[string][]byte{
+	ContentZeros:  make([]byte, blockSize),
+	ContentRandom: randomBlock(),
+	ContentText:   textBlock(),
+}
+
+func randomBlock() []byte {
+	block := make([]byte, blockSize)
+	random := rand.NewChaCha8([32]byte{})
+	random.Read(block)
+	return block
+}
+
+func textBlock() []byte {
+	const text = "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.\n"
+	return []byte(strings.Repeat(text, blockSize/len(text)+1)[:blockSize])
+}
+
+func (c Config) Validate() error {
+	if c.Size < 0 || c.MaxSize < 0 || c.Limit < 0 || c.ChunkSize < 0 {
+		return fmt.Errorf("sizes must not be negative")
+	} else if c.MaxSize != 0 && c.MaxSize < c.Size {
+		return fmt.Errorf("maxSize must not be smaller than size")
+	}
+	switch c.Content {
+	case "", ContentZeros, ContentRandom, ContentText:
+	default:
+		return fmt.Errorf("unknown content %q", c.Content)
+	}
+	switch c.Transfer {
+	case "", TransferContentLength, TransferChunked:
+	default:
+		return fmt.Errorf("unknown transfer %q", c.Transfer)
+	}
+	return nil
+}
+
+func (c Config) size(request *http.Request) (int64, error) {
+	if c.QueryParam != "" && request.URL.Query().Has(c.QueryParam) {
+		size, err := utils.ParseByteSize(request.URL.Query().Get(c.QueryParam))
+		if err != nil {
+			return 0, err
+		} else if c.Limit > 0 && size > c.Limit {
+			return 0, fmt.Errorf("requested size exceeds limit of %d bytes", c.Limit)
+		}
+		return int64(size), nil
+	} else if c.MaxSize > c.Size {
+		return int64(c.Size) + rand.Int64N(int64(c.MaxSize-c.Size)+1), nil
+	}
+	return int64(c.Size), nil
+}
+
+func NewGenerator(config Config) (*Generator, error) {
+	generator := &Generator{}
+	return generator, generator.SetConfig(config)
+}
+
+func (g *Generator) Config() Config {
+	return *g.config.Load()
+}
+
+func (g *Generator) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	g.config.Store(&config)
+	return nil
+}
+
+// Serve beantwortet den Request mit Status 200 und einem Body gemäß Konfiguration
+func (g *Generator) Serve(writer http.ResponseWriter, request *http.Request) {
+	config := g.config.Load()
+	size, err := config.size(request)
+	if err != nil {
+		utils.BadRequestError(writer, request, err)
+		return
+	} else if size == 0 {
+		writer.WriteHeader(http.StatusOK)
+		return
+	}
+
+	content := config.Content
+	if content == "" {
+		content = ContentZeros
+	}
+	if content == ContentText {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		writer.Header().Set("Content-Type", "application/octet-stream")
+	}
+	chunked := config.Transfer == TransferChunked
+	if !chunked {
+		writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	writer.WriteHeader(http.StatusOK)
+	if request.Method == http.MethodHead {
+		return
+	}
+
+	chunkSize := int64(config.ChunkSize)
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	controller := http.NewResponseController(writer)
+	block := blocks[content]
+	for written := int64(0); written < size; {
+		offset := written % int64(len(block))
+		n := min(size-written, chunkSize, int64(len(block))-offset)
+		if _, err := writer.Write(block[offset : offset+n]); err != nil {
+			return
+		}
+		written += n
+		if chunked {
+			controller.Flush()
+		}
+	}
+}
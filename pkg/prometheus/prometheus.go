@@ -0,0 +1,266 @@
+package prometheus
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/history"
+	"github.com/mwildt/load-monitor/pkg/store"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+
+	namespace = "loadmonitor"
+
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type (
+	// Mapping bildet Store-Keys auf Metriken ab. Bei Prefix-Mappings wird der Rest des Keys an den
+	// ersten Punkten auf Labels aufgeteilt, das letzte Label erhält den verbleibenden Rest.
+	Mapping struct {
+		Key    string
+		Prefix string
+		Name   string
+		Type   string
+		Labels []string
+	}
+	// Histogram: mehrere Histogramme mit gleichem Name bilden eine Familie und werden durch Labels unterschieden
+	Histogram struct {
+		Name      string
+		Help      string
+		Histogram *histogram.Histogram
+		// Unit ist die Einheit der aufgezeichneten Werte, exportiert wird in Sekunden
+		Unit   time.Duration
+		Bounds []float64
+		Labels map[string]string
+	}
+	label struct {
+		name  string
+		value string
+	}
+	sample struct {
+		suffix string
+		labels []label
+		value  float64
+	}
+	family struct {
+		name    string
+		typ     string
+		help    string
+		samples []sample
+	}
+)
+
+var (
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+	DefaultBounds = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+	DefaultMappings = []Mapping{
+		{Key: "session.count", Name: "sessions", Type: TypeGauge},
+		{Prefix: "request.count.", Name: "route_requests", Type: TypeCounter, Labels: []string{"method", "path"}},
+		{Prefix: "response.status.", Name: "responses_by_status", Type: TypeCounter, Labels: []string{"code"}},
+		{Prefix: "response.class.", Name: "responses_by_class", Type: TypeCounter, Labels: []string{"class"}},
+		{Prefix: "fault.injected.status.", Name: "faults_injected_by_status", Type: TypeCounter, Labels: []string{"code"}},
+		// die Summe behält ihren bisherigen Namen und landet nicht als kind="count" in faults_injected
+		{Key: "fault.injected.count", Name: "fault_injected", Type: TypeCounter},
+		{Prefix: "fault.injected.", Name: "faults_injected", Type: TypeCounter, Labels: []string{"kind"}},
+		{Prefix: "request.latency.", Name: "request_latency_milliseconds", Type: TypeGauge, Labels: []string{"quantile"}},
+		{Prefix: "request.rate.", Name: "request_rate", Type: TypeGauge, Labels: []string{"window"}},
+		{Prefix: "bytes.read.rate.", Name: "bytes_read_rate", Type: TypeGauge, Labels: []string{"window"}},
+		{Prefix: "bytes.write.rate.", Name: "bytes_write_rate", Type: TypeGauge, Labels: []string{"window"}},
+		{Prefix: "session.rate.", Name: "session_rate", Type: TypeGauge, Labels: []string{"window"}},
+		{Prefix: "request.protocol.", Name: "requests_by_protocol", Type: TypeCounter, Labels: []string{"protocol"}},
+		{Prefix: "tls.handshake.duration.", Name: "tls_handshake_duration_milliseconds", Type: TypeGauge, Labels: []string{"quantile"}},
+		{Prefix: "tls.version.", Name: "tls_handshakes_by_version", Type: TypeCounter, Labels: []string{"version"}},
+		{Prefix: "ws.rtt.", Name: "websocket_rtt_milliseconds", Type: TypeGauge, Labels: []string{"quantile"}},
+		{Prefix: "ws.message.rtt.", Name: "websocket_message_rtt_milliseconds", Type: TypeGauge, Labels: []string{"quantile"}},
+		{Prefix: "grpc.requests.", Name: "grpc_requests", Type: TypeCounter, Labels: []string{"method"}},
+		{Prefix: "grpc.status.", Name: "grpc_responses_by_status", Type: TypeCounter, Labels: []string{"code"}},
+		{Prefix: "grpc.latency.", Name: "grpc_latency_milliseconds", Type: TypeGauge, Labels: []string{"method", "quantile"}},
+		{Prefix: "conn.closed.", Name: "connections_closed", Type: TypeCounter, Labels: []string{"reason"}},
+		{Prefix: "conn.lifetime.", Name: "connection_lifetime_milliseconds", Type: TypeGauge, Labels: []string{"quantile"}},
+		{Prefix: "conn.requests.", Name: "requests_per_connection", Type: TypeGauge, Labels: []string{"quantile"}},
+		{Prefix: "tls.alpn.", Name: "tls_handshakes_by_alpn", Type: TypeCounter, Labels: []string{"protocol"}},
+	}
+)
+
+func sanitize(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// mapKey liefert Familienname, Typ, Hilfetext und Labels für einen Store-Key; ohne Mapping entscheidet das Suffix ".count"
+func mapKey(mappings []Mapping, key string) (string, string, string, []label) {
+	for _, mapping := range mappings {
+		if mapping.Key != "" && mapping.Key == key {
+			return namespace + "_" + mapping.Name, mapping.Type, "load-monitor store key " + key, nil
+		} else if mapping.Prefix != "" && strings.HasPrefix(key, mapping.Prefix) {
+			parts := strings.SplitN(strings.TrimPrefix(key, mapping.Prefix), ".", len(mapping.Labels))
+			labels := make([]label, 0, len(parts))
+			for i, part := range parts {
+				labels = append(labels, label{mapping.Labels[i], part})
+			}
+			return namespace + "_" + mapping.Name, mapping.Type, "load-monitor store keys " + mapping.Prefix + "*", labels
+		}
+	}
+	help := "load-monitor store key " + key
+	if strings.HasSuffix(key, ".count") {
+		return namespace + "_" + sanitize(strings.TrimSuffix(key, ".count")), TypeCounter, help, nil
+	}
+	return namespace + "_" + sanitize(key), TypeGauge, help, nil
+}
+
+func collect(mappings []Mapping, values map[string]any, histograms []Histogram) []*family {
+	families := make(map[string]*family)
+	for key, value := range values {
+		number, ok := history.Float(value)
+		if !ok {
+			continue
+		}
+		name, typ, help, labels := mapKey(mappings, key)
+		f, exists := families[name]
+		if !exists {
+			f = &family{name: name, typ: typ, help: help}
+			families[name] = f
+		}
+		suffix := ""
+		if typ == TypeCounter {
+			suffix = "_total"
+		}
+		f.samples = append(f.samples, sample{suffix, labels, number})
+	}
+	for _, h := range histograms {
+		f, exists := families[namespace+"_"+h.Name]
+		if !exists {
+			f = &family{name: namespace + "_" + h.Name, typ: TypeHistogram, help: h.Help}
+			families[f.name] = f
+		}
+		// ohne freie Kapazität legt jedes append(labels, le) ein eigenes Slice an
+		labels := make([]label, 0, len(h.Labels))
+		for name, value := range h.Labels {
+			labels = append(labels, label{name, value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		bounds := h.Bounds
+		if bounds == nil {
+			bounds = DefaultBounds
+		}
+		scaled := make([]int64, len(bounds))
+		for i, bound := range bounds {
+			scaled[i] = int64(bound * float64(time.Second) / float64(h.Unit))
+		}
+		for i, count := range h.Histogram.CumulativeCounts(scaled) {
+			f.samples = append(f.samples, sample{"_bucket", append(labels, label{"le", formatFloat(bounds[i])}), float64(count)})
+		}
+		count := float64(h.Histogram.Count())
+		f.samples = append(f.samples,
+			sample{"_bucket", append(labels, label{"le", "+Inf"}), count},
+			sample{"_sum", labels, float64(h.Histogram.Sum()) * float64(h.Unit) / float64(time.Second)},
+			sample{"_count", labels, count},
+		)
+	}
+
+	res := make([]*family, 0, len(families))
+	for _, f := range families {
+		if f.typ != TypeHistogram {
+			sort.Slice(f.samples, func(i, j int) bool {
+				return labelSuffix(f.samples[i].labels) < labelSuffix(f.samples[j].labels)
+			})
+		}
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+func labelSuffix(labels []label) string {
+	values := make([]string, len(labels))
+	for i, l := range labels {
+		values[i] = l.value
+	}
+	return strings.Join(values, ".")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Render schreibt alle numerischen Store-Werte und Histogramme im Prometheus-Textformat bzw. als OpenMetrics
+func Render(w io.Writer, mappings []Mapping, values map[string]any, histograms []Histogram, openMetrics bool) error {
+	for _, f := range collect(mappings, values, histograms) {
+		name := f.name
+		if f.typ == TypeCounter && !openMetrics {
+			name += "_total"
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escape(f.help), name, f.typ); err != nil {
+			return err
+		}
+		for _, s := range f.samples {
+			fmt.Fprint(w, f.name, s.suffix)
+			if len(s.labels) > 0 {
+				pairs := make([]string, len(s.labels))
+				for i, l := range s.labels {
+					pairs[i] = fmt.Sprintf(`%s="%s"`, l.name, escape(l.value))
+				}
+				fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+			}
+			if _, err := fmt.Fprintf(w, " %s\n", formatFloat(s.value)); err != nil {
+				return err
+			}
+		}
+	}
+	if openMetrics {
+		_, err := fmt.Fprint(w, "# EOF\n")
+		return err
+	}
+	return nil
+}
+
+// Handler liefert /metrics; ist token gesetzt, wird "Authorization: Bearer <token>" verlangt
+func Handler(valueStore *store.Store, histograms []Histogram, token string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if token != "" {
+			expected := []byte("Bearer " + token)
+			if subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), expected) != 1 {
+				writer.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		openMetrics := strings.Contains(request.Header.Get("Accept"), "application/openmetrics-text") ||
+			request.URL.Query().Get("format") == "openmetrics"
+		if openMetrics {
+			writer.Header().Set("Content-Type", contentTypeOpenMetrics)
+		} else {
+			writer.Header().Set("Content-Type", contentTypeText)
+		}
+		Render(writer, DefaultMappings, valueStore.Entries(), histograms, openMetrics)
+	}
+}
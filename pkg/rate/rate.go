@@ -0,0 +1,100 @@
+package rate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/history"
+	"github.com/mwildt/load-monitor/pkg/store"
+)
+
+type (
+	sample struct {
+		time  time.Time
+		value float64
+	}
+	// Sampler leitet aus monoton steigenden Zählern Raten pro Sekunde ab. Für jedes Fenster (in Samples)
+	// wird "<prefix>.<fenster>s" veröffentlicht, z.B. request.count -> request.rate.1s, request.rate.10s
+	Sampler struct {
+		counters map[string]string
+		windows  []int
+		samples  map[string][]sample
+	}
+)
+
+func NewSampler(counters map[string]string, windows ...int) *Sampler {
+	return &Sampler{
+		counters: counters,
+		windows:  windows,
+		samples:  make(map[string][]sample),
+	}
+}
+
+func (s *Sampler) Keys() []string {
+	keys := make([]string, 0)
+	for _, prefix := range s.counters {
+		for _, window := range s.windows {
+			keys = append(keys, fmt.Sprintf("%s.%ds", prefix, window))
+		}
+	}
+	return keys
+}
+
+func (s *Sampler) maxWindow() int {
+	res := 0
+	for _, window := range s.windows {
+		res = max(res, window)
+	}
+	return res
+}
+
+// Sample nimmt die aktuellen Zählerstände auf und liefert die daraus berechneten Raten
+func (s *Sampler) Sample(now time.Time, values map[string]any) map[string]float64 {
+	rates := make(map[string]float64)
+	for counter, prefix := range s.counters {
+		value, ok := history.Float(values[counter])
+		if !ok {
+			continue
+		}
+		samples := s.samples[counter]
+		if len(samples) > 0 && value < samples[len(samples)-1].value {
+			// Zähler wurde zurückgesetzt
+			samples = samples[:0]
+		}
+		samples = append(samples, sample{now, value})
+		if len(samples) > s.maxWindow()+1 {
+			samples = samples[1:]
+		}
+		s.samples[counter] = samples
+		for _, window := range s.windows {
+			oldest := samples[max(0, len(samples)-1-window)]
+			rate := 0.0
+			if elapsed := now.Sub(oldest.time).Seconds(); elapsed > 0 {
+				rate = (value - oldest.value) / elapsed
+			}
+			rates[fmt.Sprintf("%s.%ds", prefix, window)] = rate
+		}
+	}
+	return rates
+}
+
+// Run tastet die Zähler im Abstand interval ab und schreibt die Raten in den Store
+func (s *Sampler) Run(ctx context.Context, valueStore *store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := make(map[string]float64)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for key, rate := range s.Sample(now, valueStore.Entries()) {
+				if previous, exists := last[key]; !exists || previous != rate {
+					valueStore.Set(key, rate)
+					last[key] = rate
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package recorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+type (
+	Record struct {
+		Time       time.Time           `json:"time"`
+		Method     string              `json:"method"`
+		Path       string              `json:"path"`
+		Query      string              `json:"query,omitempty"`
+		Headers    map[string][]string `json:"headers"`
+		BodySize   int64               `json:"bodySize"`
+		BodySha256 string              `json:"bodySha256"`
+		Latency    utils.Duration      `json:"latency"`
+		Status     int                 `json:"status"`
+		SessionId  string              `json:"sessionId,omitempty"`
+	}
+	// Recorder hängt Records an eine JSONL-Datei an. Überschreitet sie maxSize, wird sie nach
+	// <filename>.1 ... <filename>.<maxFiles> rotiert.
+	Recorder struct {
+		mu       sync.Mutex
+		filename string
+		maxSize  int64
+		maxFiles int
+		file     *os.File
+		size     int64
+	}
+	// DigestReader berechnet Größe und SHA-256 eines Request-Bodys, während der Handler ihn liest
+	DigestReader struct {
+		io.ReadCloser
+		hash hash.Hash
+		size int64
+	}
+)
+
+func NewRecorder(filename string, maxSize int64, maxFiles int) (*Recorder, error) {
+	recorder := &Recorder{filename: filename, maxSize: maxSize, maxFiles: maxFiles}
+	if err := recorder.open(); err != nil {
+		return nil, err
+	}
+	log.Printf("recording sensor requests to %s", filename)
+	return recorder, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.filename, r.maxFiles))
+	for i := r.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.filename, i), fmt.Sprintf("%s.%d", r.filename, i+1))
+	}
+	if r.maxFiles > 0 {
+		if err := os.Rename(r.filename, r.filename+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.filename); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *Recorder) Record(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func NewDigestReader(body io.ReadCloser) *DigestReader {
+	return &DigestReader{ReadCloser: body, hash: sha256.New()}
+}
+
+func (d *DigestReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// Finish liest den vom Handler nicht gelesenen Rest des Bodys und liefert Größe und Hash
+func (d *DigestReader) Finish() (int64, string) {
+	io.Copy(io.Discard, d)
+	return d.size, hex.EncodeToString(d.hash.Sum(nil))
+}
@@ -0,0 +1,235 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+// sessionCookie ist der Cookie, in dem der Sensor die Session-Id vergibt
+const sessionCookie = "sid"
+
+type (
+	ReplayResult struct {
+		Sent      int64          `json:"sent"`
+		Errors    int64          `json:"errors"`
+		Responses map[int]int64  `json:"responses"`
+		Duration  utils.Duration `json:"duration"`
+	}
+	// sessions ordnet aufgezeichnete Session-Ids den beim Replay vergebenen zu. Requests einer Session warten, bis
+	// der Login, der sie angelegt hat, beantwortet ist.
+	sessions struct {
+		mu      sync.Mutex
+		live    map[string]string
+		pending map[string]chan struct{}
+	}
+)
+
+var skippedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Host":              true,
+}
+
+// Replay sendet die Records aus reader erneut an target. Die ursprünglichen Abstände werden mit
+// scale multipliziert: 1 = Originaltiming, 0.5 = doppelte Geschwindigkeit, 0 = so schnell wie möglich.
+// Request-Bodys werden nicht aufgezeichnet und daher durch Nullbytes gleicher Größe ersetzt. Session-Cookies werden
+// auf die Sessions umgeschrieben, die die aufgezeichneten Logins beim Replay erhalten.
+func Replay(ctx context.Context, reader io.Reader, target *url.URL, scale float64, client *http.Client) (ReplayResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		sent      atomic.Int64
+		failed    atomic.Int64
+		responses = make(map[int]int64)
+		start     = time.Now()
+	)
+	records, err := readRecords(reader)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+	replayed := newSessions(records)
+	for _, record := range records {
+		offset := time.Duration(float64(record.Time.Sub(records[0].Time)) * scale)
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ReplayResult{}, ctx.Err()
+		case <-time.After(time.Until(start.Add(offset))):
+		}
+
+		request, err := newRequest(ctx, target, record)
+		if err != nil {
+			// laufende Requests und auf einen Login wartende Worker werden abgebrochen
+			cancel()
+			wg.Wait()
+			return ReplayResult{}, fmt.Errorf("%s %s: %w", record.Method, record.Path, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			login := isLogin(record)
+			if recorded := recordedSession(record); recorded != "" {
+				live, err := replayed.wait(ctx, recorded)
+				if err != nil {
+					failed.Add(1)
+					return
+				}
+				replaceSession(request, live)
+			}
+			sent.Add(1)
+			response, err := client.Do(request)
+			if login {
+				replayed.resolve(record.SessionId, response)
+			}
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			responses[response.StatusCode]++
+		}()
+	}
+	wg.Wait()
+	return ReplayResult{
+		Sent:      sent.Load(),
+		Errors:    failed.Load(),
+		Responses: responses,
+		Duration:  utils.Duration(time.Since(start)),
+	}, nil
+}
+
+// readRecords liest alle Records und sortiert sie nach Beginn des Requests. Der Recorder schreibt beim Ende des
+// Requests, die Datei ist also nach Abschluss sortiert.
+func readRecords(reader io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	slices.SortStableFunc(records, func(a, b Record) int {
+		return a.Time.Compare(b.Time)
+	})
+	return records, scanner.Err()
+}
+
+func newSessions(records []Record) *sessions {
+	s := &sessions{live: make(map[string]string), pending: make(map[string]chan struct{})}
+	for _, record := range records {
+		if isLogin(record) {
+			s.pending[record.SessionId] = make(chan struct{})
+		}
+	}
+	return s
+}
+
+// isLogin: der Request hatte keine Session, hat aber eine erhalten
+func isLogin(record Record) bool {
+	return record.SessionId != "" && recordedSession(record) == ""
+}
+
+// recordedSession liefert die Session-Id aus dem Cookie des aufgezeichneten Requests
+func recordedSession(record Record) string {
+	request := http.Request{Header: record.Headers}
+	if cookie, err := request.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// resolve übernimmt die Session aus der Antwort des Logins; ohne Antwort oder Cookie bleibt die aufgezeichnete
+func (s *sessions) resolve(recorded string, response *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	done, exists := s.pending[recorded]
+	if !exists {
+		return
+	}
+	if response != nil {
+		for _, cookie := range response.Cookies() {
+			if cookie.Name == sessionCookie && cookie.Value != "" {
+				s.live[recorded] = cookie.Value
+			}
+		}
+	}
+	delete(s.pending, recorded)
+	close(done)
+}
+
+// wait liefert die beim Replay vergebene Session zu recorded. Wurde die Session nicht im Replay angelegt (Login vor
+// Beginn der Aufzeichnung), bleibt sie unverändert.
+func (s *sessions) wait(ctx context.Context, recorded string) (string, error) {
+	s.mu.Lock()
+	done, pending := s.pending[recorded]
+	s.mu.Unlock()
+	if pending {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-done:
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if live, exists := s.live[recorded]; exists {
+		return live, nil
+	}
+	return recorded, nil
+}
+
+// replaceSession ersetzt den Wert des Session-Cookies, die übrigen Cookies bleiben erhalten
+func replaceSession(request *http.Request, sid string) {
+	cookies := request.Cookies()
+	request.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name == sessionCookie {
+			cookie.Value = sid
+		}
+		request.AddCookie(cookie)
+	}
+}
+
+func newRequest(ctx context.Context, target *url.URL, record Record) (*http.Request, error) {
+	u := *target
+	// ein Basispfad des Ziels (z.B. http://host/prefix) bleibt erhalten
+	u.Path = strings.TrimSuffix(target.Path, "/") + record.Path
+	u.RawPath = ""
+	u.RawQuery = record.Query
+	var body io.Reader
+	if record.BodySize > 0 {
+		body = bytes.NewReader(make([]byte, record.BodySize))
+	}
+	request, err := http.NewRequestWithContext(ctx, record.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range record.Headers {
+		if !skippedHeaders[http.CanonicalHeaderKey(key)] {
+			request.Header[key] = values
+		}
+	}
+	return request, nil
+}
@@ -0,0 +1,186 @@
+package runs
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"text/template"
+	"time"
+
+	"github.com/mwildt/load-monitor/pkg/history"
+)
+
+type (
+	Metric struct {
+		Name  string  `json:"name"`
+		Unit  string  `json:"unit"`
+		Value float64 `json:"value"`
+	}
+	MetricDiff struct {
+		Name         string   `json:"name"`
+		Unit         string   `json:"unit"`
+		A            float64  `json:"a"`
+		B            float64  `json:"b"`
+		Delta        float64  `json:"delta"`
+		DeltaPercent *float64 `json:"deltaPercent,omitempty"`
+	}
+	RunInfo struct {
+		Id        string            `json:"id"`
+		Name      string            `json:"name"`
+		Labels    map[string]string `json:"labels,omitempty"`
+		StartedAt time.Time         `json:"startedAt"`
+	}
+	Comparison struct {
+		A       RunInfo      `json:"a"`
+		B       RunInfo      `json:"b"`
+		Metrics []MetricDiff `json:"metrics"`
+	}
+)
+
+func (run Run) Duration() time.Duration {
+	if run.StoppedAt != nil {
+		return run.StoppedAt.Sub(run.StartedAt)
+	}
+	return time.Since(run.StartedAt)
+}
+
+func (run Run) value(key string) float64 {
+	value, _ := history.Float(run.Metrics[key])
+	return value
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// Summarize verdichtet die Metriken eines Runs auf die für einen Vergleich relevanten Kennzahlen
+func Summarize(run Run) []Metric {
+	seconds := run.Duration().Seconds()
+	requests := run.value("request.count")
+	responses := run.value("response.class.1xx") + run.value("response.class.2xx") + run.value("response.class.3xx") +
+		run.value("response.class.4xx") + run.value("response.class.5xx")
+	errors := run.value("response.class.4xx") + run.value("response.class.5xx")
+	return []Metric{
+		{"Duration", "s", seconds},
+		{"Requests", "", requests},
+		{"Throughput", "req/s", ratio(requests, seconds)},
+		{"Bytes read", "B", run.value("bytes.read.count")},
+		{"Bytes written", "B", run.value("bytes.write.count")},
+		{"Read throughput", "B/s", ratio(run.value("bytes.read.count"), seconds)},
+		{"Write throughput", "B/s", ratio(run.value("bytes.write.count"), seconds)},
+		{"Error rate (4xx+5xx)", "%", 100 * ratio(errors, responses)},
+		{"Error rate (5xx)", "%", 100 * ratio(run.value("response.class.5xx"), responses)},
+		{"Injected faults", "", run.value("fault.injected.count")},
+		{"Latency p50", "ms", run.value("request.latency.p50")},
+		{"Latency p90", "ms", run.value("request.latency.p90")},
+		{"Latency p99", "ms", run.value("request.latency.p99")},
+		{"Latency p99.9", "ms", run.value("request.latency.p999")},
+		{"Latency max", "ms", run.value("request.latency.max")},
+	}
+}
+
+func info(run Run) RunInfo {
+	return RunInfo{Id: run.Id, Name: run.Name, Labels: run.Labels, StartedAt: run.StartedAt}
+}
+
+func Compare(a, b Run) Comparison {
+	summaryA, summaryB := Summarize(a), Summarize(b)
+	diffs := make([]MetricDiff, len(summaryA))
+	for i := range summaryA {
+		diff := MetricDiff{
+			Name:  summaryA[i].Name,
+			Unit:  summaryA[i].Unit,
+			A:     summaryA[i].Value,
+			B:     summaryB[i].Value,
+			Delta: summaryB[i].Value - summaryA[i].Value,
+		}
+		if diff.A != 0 {
+			percent := 100 * diff.Delta / math.Abs(diff.A)
+			diff.DeltaPercent = &percent
+		}
+		diffs[i] = diff
+	}
+	return Comparison{A: info(a), B: info(b), Metrics: diffs}
+}
+
+func formatValue(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return fmt.Sprintf("%.0f", value)
+	}
+	return fmt.Sprintf("%.3f", value)
+}
+
+func formatPercent(percent *float64) string {
+	if percent == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f %%", *percent)
+}
+
+var templateFuncs = map[string]any{
+	"value":   formatValue,
+	"percent": formatPercent,
+	"time":    func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(templateFuncs).Parse(`# Run comparison
+
+| | A | B |
+|---|---|---|
+| Run | {{.A.Name}} ({{.A.Id}}) | {{.B.Name}} ({{.B.Id}}) |
+| Started | {{time .A.StartedAt}} | {{time .B.StartedAt}} |
+{{- range $key, $value := .A.Labels}}
+| {{$key}} | {{$value}} | {{index $.B.Labels $key}} |
+{{- end}}
+
+| Metric | Unit | A | B | Delta | Delta % |
+|---|---|---:|---:|---:|---:|
+{{- range .Metrics}}
+| {{.Name}} | {{.Unit}} | {{value .A}} | {{value .B}} | {{value .Delta}} | {{percent .DeltaPercent}} |
+{{- end}}
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Run comparison: {{.A.Name}} vs. {{.B.Name}}</title>
+<style>
+    body { font-family: sans-serif; color: #1e3a8a; max-width: 800px; margin: 2rem auto; }
+    table { border-collapse: collapse; width: 100%; margin-bottom: 2rem; }
+    th, td { border: 1px solid #93c5fd; padding: 0.4rem 0.8rem; }
+    th { background-color: #dbeafe; text-align: left; }
+    td.number { text-align: right; font-variant-numeric: tabular-nums; }
+</style>
+</head>
+<body>
+<h1>Run comparison</h1>
+<table>
+    <tr><th></th><th>A</th><th>B</th></tr>
+    <tr><th>Run</th><td>{{.A.Name}} ({{.A.Id}})</td><td>{{.B.Name}} ({{.B.Id}})</td></tr>
+    <tr><th>Started</th><td>{{time .A.StartedAt}}</td><td>{{time .B.StartedAt}}</td></tr>
+    {{- range $key, $value := .A.Labels}}
+    <tr><th>{{$key}}</th><td>{{$value}}</td><td>{{index $.B.Labels $key}}</td></tr>
+    {{- end}}
+</table>
+<table>
+    <tr><th>Metric</th><th>Unit</th><th>A</th><th>B</th><th>Delta</th><th>Delta %</th></tr>
+    {{- range .Metrics}}
+    <tr><td>{{.Name}}</td><td>{{.Unit}}</td><td class="number">{{value .A}}</td><td class="number">{{value .B}}</td><td class="number">{{value .Delta}}</td><td class="number">{{percent .DeltaPercent}}</td></tr>
+    {{- end}}
+</table>
+</body>
+</html>
+`))
+
+func RenderMarkdown(w io.Writer, comparison Comparison) error {
+	return markdownTemplate.Execute(w, comparison)
+}
+
+func RenderHTML(w io.Writer, comparison Comparison) error {
+	return htmlTemplate.Execute(w, comparison)
+}
@@ -0,0 +1,175 @@
+package runs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+var (
+	ErrNotFound   = errors.New("run not found")
+	ErrNotRunning = errors.New("run is not running")
+)
+
+type (
+	// Run ist ein benannter Testlauf. Metrics enthält bei laufenden Runs die aktuellen, bei gestoppten die eingefrorenen Werte.
+	Run struct {
+		Id        string            `json:"id"`
+		Name      string            `json:"name"`
+		Labels    map[string]string `json:"labels,omitempty"`
+		Config    json.RawMessage   `json:"config,omitempty"`
+		Status    string            `json:"status"`
+		StartedAt time.Time         `json:"startedAt"`
+		StoppedAt *time.Time        `json:"stoppedAt,omitempty"`
+		Metrics   map[string]any    `json:"metrics,omitempty"`
+	}
+	StartRequest struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+		// Config ist die Konfiguration des getesteten Lasttest-Tools, sie wird unverändert abgelegt
+		Config json.RawMessage `json:"config,omitempty"`
+	}
+	Manager struct {
+		mu       sync.RWMutex
+		runs     map[string]*Run
+		active   string
+		snapshot func() map[string]any
+		reset    func()
+		archive  func(Run)
+	}
+)
+
+func newRunId(now time.Time) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return now.UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// NewManager erwartet eine Funktion für den Metrik-Snapshot, eine, die alle Messwerte zurücksetzt,
+// und eine, die gestoppte Runs archiviert
+func NewManager(snapshot func() map[string]any, reset func(), archive func(Run)) *Manager {
+	return &Manager{
+		runs:     make(map[string]*Run),
+		snapshot: snapshot,
+		reset:    reset,
+		archive:  archive,
+	}
+}
+
+// Restore übernimmt bereits archivierte Runs, z.B. nach einem Neustart
+func (m *Manager) Restore(runs ...Run) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, run := range runs {
+		if run.Status == StatusRunning {
+			run.Status = StatusStopped
+		}
+		m.runs[run.Id] = &run
+	}
+}
+
+// Start beendet einen ggf. laufenden Run, setzt die Messwerte zurück und startet einen neuen Run
+func (m *Manager) Start(request StartRequest) (Run, error) {
+	if request.Name == "" {
+		return Run{}, fmt.Errorf("name must not be empty")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// archiviert wird vor dem Reset, aber außerhalb des Locks; währenddessen kann ein anderer Run gestartet werden
+	for m.active != "" {
+		stopped := m.stop(m.runs[m.active])
+		m.mu.Unlock()
+		m.archive(stopped)
+		m.mu.Lock()
+	}
+	m.reset()
+	now := time.Now()
+	run := &Run{
+		Id:        newRunId(now),
+		Name:      request.Name,
+		Labels:    request.Labels,
+		Config:    request.Config,
+		Status:    StatusRunning,
+		StartedAt: now,
+	}
+	m.runs[run.Id] = run
+	m.active = run.Id
+	return m.view(run), nil
+}
+
+// stop beendet run und liefert die zu archivierende Kopie; archiviert wird außerhalb des Locks
+func (m *Manager) stop(run *Run) Run {
+	now := time.Now()
+	run.Status = StatusStopped
+	run.StoppedAt = &now
+	run.Metrics = m.snapshot()
+	m.active = ""
+	return *run
+}
+
+func (m *Manager) Stop(id string) (Run, error) {
+	m.mu.Lock()
+	run, exists := m.runs[id]
+	if !exists {
+		m.mu.Unlock()
+		return Run{}, ErrNotFound
+	} else if run.Status != StatusRunning {
+		m.mu.Unlock()
+		return Run{}, ErrNotRunning
+	}
+	stopped := m.stop(run)
+	m.mu.Unlock()
+	m.archive(stopped)
+	return stopped, nil
+}
+
+// view liefert eine Kopie; laufende Runs erhalten die aktuellen Messwerte
+func (m *Manager) view(run *Run) Run {
+	res := *run
+	if res.Status == StatusRunning {
+		res.Metrics = m.snapshot()
+	}
+	return res
+}
+
+func (m *Manager) Get(id string) (Run, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if run, exists := m.runs[id]; exists {
+		return m.view(run), nil
+	}
+	return Run{}, ErrNotFound
+}
+
+func (m *Manager) Active() (Run, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.active == "" {
+		return Run{}, false
+	}
+	return m.view(m.runs[m.active]), true
+}
+
+// List liefert alle Runs ohne Metriken, nach Startzeit sortiert
+func (m *Manager) List() []Run {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]Run, 0, len(m.runs))
+	for _, run := range m.runs {
+		summary := *run
+		summary.Metrics = nil
+		res = append(res, summary)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].StartedAt.Before(res[j].StartedAt) })
+	return res
+}
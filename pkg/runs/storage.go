@@ -0,0 +1,90 @@
+package runs
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mwildt/load-monitor/pkg/history"
+)
+
+// SchemaVersion wird erhöht, sobald sich das Format von Result inkompatibel ändert
+const SchemaVersion = 1
+
+type (
+	// Result ist das auf Platte abgelegte Ergebnis eines Runs
+	Result struct {
+		SchemaVersion int `json:"schemaVersion"`
+		Run
+		History map[string][]history.Point `json:"history,omitempty"`
+	}
+	// FileStore legt Results als <dir>/<id>.json ab
+	FileStore struct {
+		dir string
+	}
+)
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.dir, filepath.Base(id)+".json")
+}
+
+// Save schreibt zunächst in eine temporäre Datei, damit nie ein halbes Result auf Platte liegt
+func (f *FileStore) Save(result Result) error {
+	result.SchemaVersion = SchemaVersion
+	payload, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path(result.Id) + ".tmp"
+	if err = os.WriteFile(tmp, payload, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(result.Id))
+}
+
+func (f *FileStore) Load(id string) (Result, error) {
+	result, err := ReadResult(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return result, ErrNotFound
+	}
+	return result, err
+}
+
+func (f *FileStore) LoadAll() ([]Result, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		result, err := ReadResult(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].StartedAt.Before(results[j].StartedAt) })
+	return results, nil
+}
+
+func ReadResult(filename string) (result Result, err error) {
+	payload, err := os.ReadFile(filename)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(payload, &result)
+	return result, err
+}
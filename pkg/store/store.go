@@ -19,6 +19,9 @@ type (
 		defaultValues map[string]any
 		broker        *broker.Broker[Event[any]]
 		mu            sync.RWMutex
+		// done beendet je Registrierung die Goroutine von Register/RegisterThrottled, auch wenn niemand mehr liest
+		doneMu sync.Mutex
+		done   map[broker.RegistrationToken]chan struct{}
 	}
 	Predicate func(string) bool
 )
@@ -45,10 +48,11 @@ func Select(prefix string) Predicate {
 
 func NewStore(defaultValues map[string]any) *Store {
 	return &Store{
-		defaultValues: defaultValues,
+		defaultValues: cloneMap(defaultValues),
 		values:        cloneMap(defaultValues),
 		broker:        broker.NewBroker[Event[any]](),
 		mu:            sync.RWMutex{},
+		done:          make(map[broker.RegistrationToken]chan struct{}),
 	}
 }
 
@@ -59,6 +63,17 @@ func (s *Store) Set(key string, value any) {
 	s.broadcast(Event[any]{key, value})
 }
 
+// SetDefault legt einen Key nachträglich mit Default an; ein bereits vorhandener Wert bleibt bis zum Reset erhalten
+func (s *Store) SetDefault(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultValues[key] = value
+	if _, exists := s.values[key]; !exists {
+		s.values[key] = value
+		s.broadcast(Event[any]{key, value})
+	}
+}
+
 func (s *Store) Reduce(key string, reducer func(any) any) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -75,12 +90,36 @@ func (s *Store) Get(key string) any {
 
 func (s *Store) Cancel(reg broker.RegistrationToken) {
 	s.broker.Cancel(reg)
+	s.doneMu.Lock()
+	defer s.doneMu.Unlock()
+	if done, exists := s.done[reg]; exists {
+		close(done)
+		delete(s.done, reg)
+	}
+}
+
+// Close beendet alle Registrierungen, die Channels von Register und RegisterThrottled werden geschlossen
+func (s *Store) Close() {
+	s.broker.Close()
+	s.doneMu.Lock()
+	defer s.doneMu.Unlock()
+	for reg, done := range s.done {
+		close(done)
+		delete(s.done, reg)
+	}
 }
 
 func (s *Store) Reset() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	removed := s.values
 	s.values = cloneMap(s.defaultValues)
+	// dynamisch angelegte Keys werden mit nil als entfernt gemeldet
+	for key := range removed {
+		if _, exists := s.values[key]; !exists {
+			s.broadcast(Event[any]{key, nil})
+		}
+	}
 	s.broadcastAll()
 }
 
@@ -88,13 +127,46 @@ func (s *Store) broadcast(event Event[any]) {
 	s.broker.Broadcast(event)
 }
 
-func (s *Store) RegisterThrottled(predicate Predicate, delay time.Duration) (broker.RegistrationToken, chan []Event[any], error) {
-	registration, in, err := s.broker.Register()
+// subscribe registriert beim Broker; bei broker.Coalesce ersetzen sich Events mit demselben Key. done wird mit
+// Cancel bzw. Close geschlossen.
+func (s *Store) subscribe(overflow string) (broker.RegistrationToken, chan Event[any], chan struct{}, error) {
+	registration, in, err := s.broker.RegisterWith(broker.Policy[Event[any]]{
+		Overflow: overflow,
+		Key:      func(event Event[any]) string { return event.Key },
+	})
+	if err != nil {
+		return registration, nil, nil, err
+	}
+	done := make(chan struct{})
+	s.doneMu.Lock()
+	defer s.doneMu.Unlock()
+	s.done[registration] = done
+	return registration, in, done, nil
+}
+
+// Stats liefert die Statistik der Registrierungen (Subscriber, verworfene Events, ...)
+func (s *Store) Stats() broker.Stats {
+	return s.broker.Stats()
+}
+
+// RegisterThrottled: overflow ist die Policy des Brokers (broker.DropOldest, ...) für einen nicht hinterherkommenden Subscriber
+func (s *Store) RegisterThrottled(predicate Predicate, delay time.Duration, overflow string) (broker.RegistrationToken, chan []Event[any], error) {
+	registration, in, done, err := s.subscribe(overflow)
 	if err != nil {
 		return registration, nil, err
 	}
 	out := make(chan []Event[any])
+	// send gibt auf, sobald die Registrierung beendet wird
+	send := func(events []Event[any]) bool {
+		select {
+		case out <- events:
+			return true
+		case <-done:
+			return false
+		}
+	}
 	go func() {
+		defer close(out)
 		var (
 			timeBarrier = time.Now()
 			timeout     <-chan time.Time
@@ -110,7 +182,9 @@ func (s *Store) RegisterThrottled(predicate Predicate, delay time.Duration) (bro
 					if predicate(event.Key) {
 						now := time.Now()
 						if now.After(timeBarrier) {
-							out <- []Event[any]{event}
+							if !send([]Event[any]{event}) {
+								return
+							}
 							timeBarrier = now.Add(delay)
 						} else {
 							cache[event.Key] = event
@@ -128,7 +202,9 @@ func (s *Store) RegisterThrottled(predicate Predicate, delay time.Duration) (bro
 						for _, event := range cache {
 							events = append(events, event)
 						}
-						out <- events
+						if !send(events) {
+							return
+						}
 						cache = make(map[string]Event[any])
 					}
 					timeBarrier = time.Now().Add(delay)
@@ -136,30 +212,35 @@ func (s *Store) RegisterThrottled(predicate Predicate, delay time.Duration) (bro
 				}
 			}
 		}
-		close(out)
 	}()
 	return registration, out, nil
 }
 
-func (s *Store) Register(predicate Predicate) (broker.RegistrationToken, chan Event[any], error) {
-	registration, in, err := s.broker.Register()
+func (s *Store) Register(predicate Predicate, overflow string) (broker.RegistrationToken, chan Event[any], error) {
+	registration, in, done, err := s.subscribe(overflow)
 	if err != nil {
 		return registration, nil, err
 	}
 	out := make(chan Event[any])
 	go func() {
+		defer close(out)
 		for event := range in {
 			if predicate(event.Key) {
-				out <- event
+				select {
+				case out <- event:
+				case <-done:
+					return
+				}
 			}
 		}
-		close(out)
 	}()
 	return registration, out, nil
 }
 
 func (s *Store) Entries() map[string]any {
-	return s.values
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return cloneMap(s.values)
 }
 
 func (s *Store) broadcastAll() {
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ByteSize wird in JSON als Zahl oder als String mit Einheit ("512", "10kB", "1MiB") akzeptiert
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"kB", 1000},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func ParseByteSize(value string) (ByteSize, error) {
+	value = strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", value)
+	} else if math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("byte size must be a finite number")
+	} else if number < 0 {
+		return 0, fmt.Errorf("byte size must not be negative")
+	}
+	// float64(math.MaxInt64) ist 2^63, größere Werte würden bei der Umwandlung negativ
+	size := number * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("byte size %q is too large", value)
+	}
+	return ByteSize(size), nil
+}
+
+func (s ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(s))
+}
+
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var number int64
+	if err := json.Unmarshal(data, &number); err == nil {
+		*s = ByteSize(number)
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("byte size must be a number or a string like \"1MiB\"")
+	} else if parsed, err := ParseByteSize(value); err != nil {
+		return err
+	} else {
+		*s = parsed
+		return nil
+	}
+}
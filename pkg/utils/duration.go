@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration wird in JSON als Go-Duration-String ("250ms", "1.5s") geschrieben und gelesen
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"250ms\": %w", err)
+	} else if parsed, err := time.ParseDuration(value); err != nil {
+		return err
+	} else {
+		*d = Duration(parsed)
+		return nil
+	}
+}
@@ -6,6 +6,12 @@ import (
 	"strings"
 )
 
+// Pattern ist ein vorkompiliertes Pattern für Match, für Routen und Regeln, die je Request geprüft werden
+type Pattern struct {
+	method string
+	re     *regexp.Regexp
+}
+
 func parsePattern(pattern string) (method, path string) {
 	parts := strings.SplitN(pattern, "::", 2)
 	if len(parts) == 1 {
@@ -14,19 +20,27 @@ func parsePattern(pattern string) (method, path string) {
 	return parts[0], parts[1]
 }
 
-func matchPattern(pattern string, path string) bool {
+func compilePath(pattern string) *regexp.Regexp {
 	rePattern := "^" + regexp.QuoteMeta(pattern) + "$"
 	rePattern = strings.ReplaceAll(rePattern, "\\*\\*", ".*") // ** = alles inkl. /
 	rePattern = strings.ReplaceAll(rePattern, "\\*", "[^/]*") // * = alles außer /
 	rePattern = strings.ReplaceAll(rePattern, "\\?", ".")     // ? = ein Zeichen
-	re := regexp.MustCompile(rePattern)
-	return re.MatchString(path)
+	return regexp.MustCompile(rePattern)
 }
 
-func Match(pattern string, request *http.Request) bool {
+func CompilePattern(pattern string) *Pattern {
 	method, path := parsePattern(pattern)
-	if method != "*" && request.Method != method {
+	return &Pattern{method: method, re: compilePath(path)}
+}
+
+func (p *Pattern) Match(request *http.Request) bool {
+	if p.method != "*" && request.Method != p.method {
 		return false
 	}
-	return matchPattern(path, request.URL.Path)
+	return p.re.MatchString(request.URL.Path)
+}
+
+// Match kompiliert pattern bei jedem Aufruf, im Request-Pfad CompilePattern verwenden
+func Match(pattern string, request *http.Request) bool {
+	return CompilePattern(pattern).Match(request)
 }
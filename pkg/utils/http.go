@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"bufio"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 )
 
@@ -48,6 +50,14 @@ func BadRequestJson(w http.ResponseWriter, request *http.Request, data interface
 	SendJson(w, request, http.StatusBadRequest, data)
 }
 
+type ErrorMessage struct {
+	Error string `json:"error"`
+}
+
+func BadRequestError(w http.ResponseWriter, request *http.Request, err error) {
+	BadRequestJson(w, request, ErrorMessage{err.Error()})
+}
+
 func InternalServerError(w http.ResponseWriter, request *http.Request, err error) {
 	log.Println(err.Error())
 	SendStatus(w, request, http.StatusInternalServerError)
@@ -78,3 +88,19 @@ func (lrw *StatusLoggingResponseWriter) WriteHeader(code int) {
 	lrw.Status = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap erlaubt http.ResponseController den Zugriff auf Flush, Hijack usw. des eigentlichen Writers
+func (lrw *StatusLoggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+func (lrw *StatusLoggingResponseWriter) Flush() {
+	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack wird z.B. für WebSocket-Upgrades benötigt, die auf http.Hijacker prüfen
+func (lrw *StatusLoggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(lrw.ResponseWriter).Hijack()
+}
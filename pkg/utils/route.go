@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const OtherRoute = "_other"
+
+var (
+	knownMethods = map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodPost:    true,
+		http.MethodPut:     true,
+		http.MethodPatch:   true,
+		http.MethodDelete:  true,
+		http.MethodConnect: true,
+		http.MethodOptions: true,
+		http.MethodTrace:   true,
+	}
+	idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F-]{16,}|[A-Za-z0-9_=-]{32,})$`)
+)
+
+// NormalizePath bereinigt einen Pfad und ersetzt ID-artige Segmente (Zahlen, UUIDs, Tokens) durch ":id"
+func NormalizePath(p string) string {
+	p = path.Clean("/" + p)
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if idSegment.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func NormalizeMethod(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// RouteKeys bildet Requests auf "<METHOD>.<path>" ab; nach limit verschiedenen Pfaden landet alles weitere in OtherRoute
+type RouteKeys struct {
+	mu    sync.Mutex
+	limit int
+	paths map[string]struct{}
+}
+
+func NewRouteKeys(limit int) *RouteKeys {
+	return &RouteKeys{
+		limit: limit,
+		paths: make(map[string]struct{}),
+	}
+}
+
+func (r *RouteKeys) Key(request *http.Request) string {
+	return NormalizeMethod(request.Method) + "." + r.path(NormalizePath(request.URL.Path))
+}
+
+func (r *RouteKeys) path(p string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.paths[p]; exists {
+		return p
+	} else if len(r.paths) >= r.limit {
+		return OtherRoute
+	}
+	r.paths[p] = struct{}{}
+	return p
+}
+
+func (r *RouteKeys) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths = make(map[string]struct{})
+}
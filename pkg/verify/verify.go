@@ -0,0 +1,150 @@
+package verify
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mwildt/load-monitor/pkg/history"
+)
+
+const (
+	VerdictPass = "pass"
+	VerdictFail = "fail"
+)
+
+type (
+	// Report ist die Zusammenfassung, die das Lasttest-Tool selbst ermittelt hat. Nicht gesetzte Werte werden nicht geprüft.
+	Report struct {
+		Requests      *float64           `json:"requests,omitempty"`
+		BytesSent     *float64           `json:"bytesSent,omitempty"`
+		BytesReceived *float64           `json:"bytesReceived,omitempty"`
+		Errors        *float64           `json:"errors,omitempty"`
+		Latency       map[string]float64 `json:"latency,omitempty"`
+	}
+	// Tolerance: eine Abweichung ist zulässig, wenn sie Absolute oder Relative Prozent des gemessenen Werts nicht überschreitet
+	Tolerance struct {
+		Relative float64 `json:"relative"`
+		Absolute float64 `json:"absolute"`
+	}
+	Request struct {
+		Report
+		// Tolerances je Metrik (z.B. "requests", "latency.p99"), "default" gilt für alle übrigen
+		Tolerances map[string]Tolerance `json:"tolerances,omitempty"`
+	}
+	Check struct {
+		Metric       string    `json:"metric"`
+		Reported     float64   `json:"reported"`
+		Measured     float64   `json:"measured"`
+		Delta        float64   `json:"delta"`
+		DeltaPercent *float64  `json:"deltaPercent,omitempty"`
+		Tolerance    Tolerance `json:"tolerance"`
+		Pass         bool      `json:"pass"`
+	}
+	Verdict struct {
+		Verdict string  `json:"verdict"`
+		Checks  []Check `json:"checks"`
+	}
+)
+
+var (
+	DefaultTolerances = map[string]Tolerance{
+		"default":       {Relative: 1},
+		"bytesSent":     {Relative: 5},
+		"bytesReceived": {Relative: 5},
+		"latency":       {Relative: 10, Absolute: 1},
+	}
+	latencyKeys = map[string]string{
+		"p50":  "request.latency.p50",
+		"p90":  "request.latency.p90",
+		"p99":  "request.latency.p99",
+		"p999": "request.latency.p999",
+		"max":  "request.latency.max",
+	}
+	// Fehler ohne 4xx/5xx-Antwort, die ein Client trotzdem als Fehler sieht
+	connectionFaultKeys = []string{"fault.injected.reset", "fault.injected.hang", "fault.injected.close-mid-body"}
+)
+
+func sum(measured map[string]any, keys ...string) float64 {
+	res := 0.0
+	for _, key := range keys {
+		value, _ := history.Float(measured[key])
+		res += value
+	}
+	return res
+}
+
+// tolerance sucht zuerst in den Toleranzen des Requests (Metrik, Gruppe, "default"), dann in DefaultTolerances
+func (r Request) tolerance(metric string, group string) Tolerance {
+	for _, tolerances := range []map[string]Tolerance{r.Tolerances, DefaultTolerances} {
+		for _, key := range []string{metric, group, "default"} {
+			if tolerance, exists := tolerances[key]; exists && key != "" {
+				return tolerance
+			}
+		}
+	}
+	return Tolerance{}
+}
+
+func check(metric string, reported, measured float64, tolerance Tolerance) Check {
+	delta := reported - measured
+	res := Check{
+		Metric:    metric,
+		Reported:  reported,
+		Measured:  measured,
+		Delta:     delta,
+		Tolerance: tolerance,
+		Pass:      math.Abs(delta) <= tolerance.Absolute || math.Abs(delta) <= tolerance.Relative/100*math.Abs(measured),
+	}
+	if measured != 0 {
+		percent := 100 * delta / math.Abs(measured)
+		res.DeltaPercent = &percent
+	}
+	return res
+}
+
+func (r Request) Validate() error {
+	for metric, tolerance := range r.Tolerances {
+		if tolerance.Relative < 0 || tolerance.Absolute < 0 {
+			return fmt.Errorf("tolerance %s must not be negative", metric)
+		}
+	}
+	for quantile := range r.Latency {
+		if _, exists := latencyKeys[quantile]; !exists {
+			return fmt.Errorf("unknown latency quantile %q", quantile)
+		}
+	}
+	return nil
+}
+
+// Verify vergleicht den Report des Tools mit den vom Server gemessenen Store-Werten
+func Verify(measured map[string]any, request Request) Verdict {
+	checks := make([]Check, 0)
+	add := func(metric string, group string, reported *float64, value float64) {
+		if reported != nil {
+			checks = append(checks, check(metric, *reported, value, request.tolerance(metric, group)))
+		}
+	}
+	add("requests", "", request.Requests, sum(measured, "request.count"))
+	add("bytesSent", "", request.BytesSent, sum(measured, "bytes.read.count"))
+	add("bytesReceived", "", request.BytesReceived, sum(measured, "bytes.write.count"))
+	add("errors", "", request.Errors, sum(measured, append([]string{"response.class.4xx", "response.class.5xx"}, connectionFaultKeys...)...))
+
+	quantiles := make([]string, 0, len(request.Latency))
+	for quantile := range request.Latency {
+		quantiles = append(quantiles, quantile)
+	}
+	sort.Strings(quantiles)
+	for _, quantile := range quantiles {
+		reported := request.Latency[quantile]
+		add("latency."+quantile, "latency", &reported, sum(measured, latencyKeys[quantile]))
+	}
+
+	verdict := Verdict{Verdict: VerdictPass, Checks: checks}
+	for _, c := range checks {
+		if !c.Pass {
+			verdict.Verdict = VerdictFail
+		}
+	}
+	return verdict
+}
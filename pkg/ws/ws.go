@@ -0,0 +1,331 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mwildt/load-monitor/pkg/histogram"
+	"github.com/mwildt/load-monitor/pkg/metric"
+	"github.com/mwildt/load-monitor/pkg/utils"
+)
+
+const (
+	ModeEcho = "echo"
+	ModePush = "push"
+	ModeSink = "sink"
+
+	maxMessageSize = 16 << 20
+	// readBufferSize muss gesetzt sein, sonst liest gorilla über den Reader des Hijacks an frameConn vorbei
+	readBufferSize = 4 << 10
+	maxRate        = 1e6
+	// minRate verhindert Ticker-Intervalle, die nicht mehr als Duration darstellbar sind
+	minRate = 0.001
+)
+
+type (
+	// Config gilt für neue Verbindungen; Mode, Rate und Size können per Query (?mode=push&rate=100&size=1KiB) überschrieben werden
+	Config struct {
+		Mode string `json:"mode,omitempty"`
+		// Rate ist die Anzahl Nachrichten pro Sekunde im Push-Modus
+		Rate float64        `json:"rate,omitempty"`
+		Size utils.ByteSize `json:"size,omitempty"`
+		// PingInterval 0 deaktiviert Pings und damit die RTT-Messung
+		PingInterval utils.Duration `json:"pingInterval,omitempty"`
+	}
+	Handler struct {
+		config      atomic.Pointer[Config]
+		rtt         *histogram.Histogram
+		messageRtt  *histogram.Histogram
+		upgrader    websocket.Upgrader
+		mu          sync.Mutex
+		conns       map[*websocket.Conn]struct{}
+		open        *metric.Gauge
+		connections *metric.Counter
+		closeErrors *metric.Counter
+		in          direction
+		out         direction
+	}
+	// direction zählt Nachrichten und deren Nutzdaten (payload) sowie die Bytes aller Frames auf der Leitung (frames),
+	// also inklusive Frame-Header, Ping/Pong/Close und der Antwort des Handshakes
+	direction struct {
+		messages *metric.Counter
+		payload  *metric.Counter
+		frames   *metric.Counter
+	}
+	// frameConn zählt die rohen Bytes der übernommenen Verbindung
+	frameConn struct {
+		net.Conn
+		in  *metric.Counter
+		out *metric.Counter
+	}
+	// hijackWriter reicht beim Upgrade statt der Verbindung eine frameConn an gorilla weiter
+	hijackWriter struct {
+		http.ResponseWriter
+		in  *metric.Counter
+		out *metric.Counter
+	}
+)
+
+func (c Config) Validate() error {
+	switch c.Mode {
+	case "", ModeEcho, ModePush, ModeSink:
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	if math.IsNaN(c.Rate) || math.IsInf(c.Rate, 0) {
+		return fmt.Errorf("rate must be a finite number")
+	} else if c.Rate != 0 && (c.Rate < minRate || c.Rate > maxRate) {
+		return fmt.Errorf("rate must be 0 (default) or between %g and %g messages per second", minRate, float64(maxRate))
+	} else if c.Size < 0 || c.Size > maxMessageSize {
+		return fmt.Errorf("size must be between 0 and %d bytes", maxMessageSize)
+	} else if c.PingInterval < 0 {
+		return fmt.Errorf("pingInterval must not be negative")
+	}
+	return nil
+}
+
+// forRequest wendet die Query-Parameter auf die Konfiguration an
+func (c Config) forRequest(request *http.Request) (Config, error) {
+	query := request.URL.Query()
+	if query.Has("mode") {
+		c.Mode = query.Get("mode")
+	}
+	if query.Has("rate") {
+		rate, err := strconv.ParseFloat(query.Get("rate"), 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid rate %q", query.Get("rate"))
+		}
+		c.Rate = rate
+	}
+	if query.Has("size") {
+		size, err := utils.ParseByteSize(query.Get("size"))
+		if err != nil {
+			return c, err
+		}
+		c.Size = size
+	}
+	if c.Mode == "" {
+		c.Mode = ModeEcho
+	}
+	if c.Rate == 0 {
+		c.Rate = 1
+	}
+	return c, c.Validate()
+}
+
+// NewHandler registriert die Metriken unter ws.* und zeichnet die Ping/Pong-Laufzeiten in rtt auf. Schickt der
+// Client im Push-Modus Nachrichten zurück, landet die Laufzeit anhand des Zeitstempels in messageRtt.
+func NewHandler(config Config, registry *metric.Registry, rtt, messageRtt *histogram.Histogram) (*Handler, error) {
+	handler := &Handler{
+		rtt:        rtt,
+		messageRtt: messageRtt,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize: readBufferSize,
+			CheckOrigin:    func(*http.Request) bool { return true },
+		},
+		conns:       make(map[*websocket.Conn]struct{}),
+		open:        registry.Gauge("ws.open", metric.Meta{Label: "Open WebSockets", Description: "open websocket connections"}),
+		connections: registry.Counter("ws.connections.count", metric.Meta{Description: "accepted websocket connections"}),
+		closeErrors: registry.Counter("ws.close.error.count", metric.Meta{Description: "websocket connections ended without close frame"}),
+		in: direction{
+			messages: registry.Counter("ws.messages.in.count", metric.Meta{Label: "WebSocket Messages in", Description: "received websocket messages"}),
+			payload:  registry.Counter("ws.payload.in.count", metric.Meta{Unit: metric.UnitBytes, Description: "received websocket message payload bytes"}),
+			frames:   registry.Counter("ws.frame.in.count", metric.Meta{Unit: metric.UnitBytes, Description: "received websocket frame bytes including headers and control frames"}),
+		},
+		out: direction{
+			messages: registry.Counter("ws.messages.out.count", metric.Meta{Label: "WebSocket Messages out", Description: "sent websocket messages"}),
+			payload:  registry.Counter("ws.payload.out.count", metric.Meta{Unit: metric.UnitBytes, Description: "sent websocket message payload bytes"}),
+			frames:   registry.Counter("ws.frame.out.count", metric.Meta{Unit: metric.UnitBytes, Description: "sent websocket frame bytes including headers and control frames"}),
+		},
+	}
+	return handler, handler.SetConfig(config)
+}
+
+func (h *Handler) Config() Config {
+	return *h.config.Load()
+}
+
+func (h *Handler) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	h.config.Store(&config)
+	return nil
+}
+
+// Upgrade führt den Handshake aus; danach läuft die Verbindung unabhängig vom Request in einer eigenen Goroutine.
+// Das Ergebnis ist false, wenn kein Upgrade stattgefunden hat (die Antwort ist dann bereits geschrieben).
+func (h *Handler) Upgrade(writer http.ResponseWriter, request *http.Request) bool {
+	config, err := h.Config().forRequest(request)
+	if err != nil {
+		utils.BadRequestError(writer, request, err)
+		return false
+	}
+	conn, err := h.upgrader.Upgrade(&hijackWriter{ResponseWriter: writer, in: h.in.frames, out: h.out.frames}, request, nil)
+	if err != nil {
+		return false
+	}
+	conn.SetReadLimit(maxMessageSize)
+	h.mu.Lock()
+	h.conns[conn] = struct{}{}
+	h.open.Set(float64(len(h.conns)))
+	h.mu.Unlock()
+	h.connections.Inc()
+	go h.serve(conn, config)
+	return true
+}
+
+// Close beendet alle offenen Verbindungen mit "going away"
+func (h *Handler) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	for conn := range h.conns {
+		conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+func (h *Handler) serve(conn *websocket.Conn, config Config) {
+	start := time.Now()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		conn.Close()
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.open.Set(float64(len(h.conns)))
+		h.mu.Unlock()
+	}()
+
+	if config.PingInterval > 0 {
+		conn.SetPongHandler(func(data string) error {
+			if len(data) == 8 {
+				sent := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(data))))
+				h.rtt.Record(time.Since(sent).Nanoseconds())
+			}
+			return nil
+		})
+		go h.ping(conn, config.PingInterval.Duration(), done)
+	}
+	if config.Mode == ModePush {
+		go h.push(conn, config, done)
+	}
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				h.closeErrors.Inc()
+			}
+			return
+		}
+		h.in.count(len(data))
+		if config.Mode == ModePush {
+			h.recordMessageRtt(start, data)
+		} else if config.Mode == ModeEcho {
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+			h.out.count(len(data))
+		}
+	}
+}
+
+// recordMessageRtt wertet zurückgeschickte Push-Nachrichten aus; Zeitstempel außerhalb der Verbindungsdauer
+// stammen nicht vom Server und werden ignoriert
+func (h *Handler) recordMessageRtt(start time.Time, data []byte) {
+	if len(data) < 8 {
+		return
+	}
+	now := time.Now()
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	if !sent.Before(start) && !sent.After(now) {
+		h.messageRtt.Record(now.Sub(sent).Nanoseconds())
+	}
+}
+
+// ping sendet den Sendezeitpunkt als Payload, der Pong-Handler berechnet daraus die RTT
+func (h *Handler) ping(conn *websocket.Conn, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	payload := make([]byte, 8)
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			binary.BigEndian.PutUint64(payload, uint64(now.UnixNano()))
+			if err := conn.WriteControl(websocket.PingMessage, payload, now.Add(interval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// push sendet Nachrichten mit fester Rate; die ersten 8 Byte enthalten den Sendezeitpunkt (Unix-Nanosekunden, big endian)
+func (h *Handler) push(conn *websocket.Conn, config Config, done chan struct{}) {
+	ticker := time.NewTicker(max(time.Nanosecond, time.Duration(float64(time.Second)/config.Rate)))
+	defer ticker.Stop()
+	message := make([]byte, config.Size)
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if len(message) >= 8 {
+				binary.BigEndian.PutUint64(message, uint64(now.UnixNano()))
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+				return
+			}
+			h.out.count(len(message))
+		}
+	}
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &frameConn{Conn: conn, in: w.in, out: w.out}, rw, nil
+}
+
+// Unwrap erlaubt http.ResponseController den Zugriff auf den ursprünglichen Writer
+func (w *hijackWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// NetConn liefert die unterliegende Verbindung (analog zu tls.Conn)
+func (c *frameConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *frameConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+func (c *frameConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+func (d direction) count(size int) {
+	d.messages.Inc()
+	d.payload.Add(int64(size))
+}
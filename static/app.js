@@ -8,6 +8,22 @@ function formatBytes(bytes) {
     return (bytes / Math.pow(k, i)).toFixed(2) + ' ' + sizes[i];
 }
 
+function formatMillis(millis) {
+    return millis.toFixed(2) + ' ms';
+}
+
+const formatters = {
+    "bytes": formatBytes,
+    "bytes/s": a => formatBytes(a) + '/s',
+    "ms": formatMillis,
+    "1/s": a => a.toFixed(1),
+}
+
+function formatValue(meta, value) {
+    const formatter = formatters[meta.unit] ?? (a => a)
+    return formatter(value)
+}
+
 function event(key, detail) {
     return new CustomEvent(key, {bubbles: true, composed: true, detail: detail});
 }
@@ -162,34 +178,26 @@ customElements.define('metrics-container', class extends LitElement {
         .metric {
             width: 20%
         }
+        .breakdown {
+            width: 100%;
+            color: #1e3a8a;
+        }
+        .breakdown td:last-child {
+            text-align: right;
+        }
     `
 
     static properties = {
-        values: {}
+        values: {},
+        meta: {}
     }
 
     constructor() {
         super();
         this.values = {}
 
-        this.meta = {
-            "bytes.write.count": {
-                label: "Total Bytes written",
-                formatter: formatBytes
-            },
-            "bytes.read.count": {
-                label: "Total Bytes read",
-                formatter: formatBytes
-            },
-            "request.count": {
-                label: "Total Requests",
-                formatter: a => a
-            },
-            "session.count": {
-                label: "Active Sessions",
-                formatter: a => a
-            },
-        }
+        // Beschreibungen der Metriken, kommen als erstes Event über den Stream (store.meta)
+        this.meta = []
     }
 
     connectedCallback() {
@@ -198,30 +206,53 @@ customElements.define('metrics-container', class extends LitElement {
         eventSource.onmessage = () => {}
         eventSource.onerror = (err) => console.error(err);
         eventSource.addEventListener("ping", () => {});
+        eventSource.addEventListener("store.meta", (event) => {
+            this.meta = JSON.parse(atob(event.data));
+        });
         eventSource.addEventListener("store.event", (event) => {
             const decodedString = atob(event.data);
             const response = JSON.parse(decodedString);
-            this.values = {...this.values, [response.key]:response.value}
+            if (response.value === null) {
+                const {[response.key]: _, ...values} = this.values
+                this.values = values
+            } else {
+                this.values = {...this.values, [response.key]:response.value}
+            }
         });
     }
 
-    renderItem(key, description) {
-        const value = this.values[key] !== undefined
-            ? description.formatter(this.values[key])
+    renderItem(meta) {
+        const value = this.values[meta.key] !== undefined
+            ? formatValue(meta, this.values[meta.key])
             : "--";
 
-        return html`<box-container class="metric">
-            <h3>${description.label}</h3>
+        return html`<box-container class="metric" title=${meta.description ?? ""}>
+            <h3>${meta.label}</h3>
             <p>${value}</p>
         </box-container>`
     }
 
+    renderBreakdown(meta) {
+        const prefix = meta.key
+        const entries = Object.entries(this.values)
+            .filter(([key]) => key.startsWith(prefix))
+            .sort(([a], [b]) => a.localeCompare(b))
+        if (entries.length === 0) {
+            return html``
+        }
+        return html`<h3>${meta.label}</h3>
+            <table class="breakdown">
+                ${entries.map(([key, value]) => html`<tr><td>${key.substring(prefix.length)}</td><td>${formatValue(meta, value)}</td></tr>`)}
+            </table>`
+    }
+
     render() {
         return html`<div>
             <h2>Metrics</h2>
             <div class="metrics">
-                ${Object.entries(this.meta).map(([key, value]) => this.renderItem(key, value))}
+                ${this.meta.filter(meta => meta.label && !meta.prefix).map(meta => this.renderItem(meta))}
             </div>
+            ${this.meta.filter(meta => meta.label && meta.prefix).map(meta => this.renderBreakdown(meta))}
             <w-button @click=${e => this.reset()}>Reset</w-button>
         </div>`;
     }